@@ -101,10 +101,17 @@ func TestAuthenticateToken_InvalidKey_Validator(t *testing.T) {
 	assert.Equal(t, engine.ErrUnauthenticated, err)
 }
 
-func TestAuthenticateToken_NoKeysConfigured(t *testing.T) {
-	auth, _ := NewAuthenticator()
-	_, err := auth.AuthenticateToken("any-key")
-	assert.Equal(t, engine.ErrUnauthenticated, err)
+func TestNewAuthenticator_NoKeysConfigured(t *testing.T) {
+	_, err := NewAuthenticator()
+	assert.NotNil(t, err)
+}
+
+func TestNewAuthenticator_ClaimsForUnknownKey(t *testing.T) {
+	_, err := NewAuthenticator(
+		WithKeys([]string{"key-1"}),
+		WithKeyClaims("key-2", map[string]interface{}{engine.ClaimFieldSubject: "bob"}),
+	)
+	assert.NotNil(t, err)
 }
 
 // ---------------------------------------------------------------------------
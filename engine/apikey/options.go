@@ -1,5 +1,7 @@
 package apikey
 
+import "errors"
+
 // KeyValidator is a callback that validates an API key and returns the
 // claims associated with it (e.g. subject, scopes).
 // Return false to reject the key.
@@ -19,33 +21,56 @@ type Options struct {
 	validator KeyValidator
 }
 
-type Option func(o *Options)
+type Option func(o *Options) error
 
 // WithKeys sets the static set of valid API keys.
 func WithKeys(keys []string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		o.keys = make(map[string]bool)
 		for _, k := range keys {
+			if k == "" {
+				return errors.New("API key must not be empty")
+			}
 			o.keys[k] = true
 		}
+		return nil
 	}
 }
 
 // WithKeyClaims associates a specific API key with a set of claims.
 // When the key is validated, the associated claims will be returned.
 func WithKeyClaims(apiKey string, claims map[string]interface{}) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		if o.claims == nil {
 			o.claims = make(map[string]map[string]interface{})
 		}
 		o.claims[apiKey] = claims
+		return nil
 	}
 }
 
 // WithValidator sets a callback for validating API keys and returning
 // associated claims from an external source.
 func WithValidator(fn KeyValidator) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		o.validator = fn
+		return nil
+	}
+}
+
+// validate checks that at least one way of validating keys is configured,
+// and that per-key claims refer to configured keys.
+func (o *Options) validate() error {
+	if o.validator != nil {
+		return nil
+	}
+	if len(o.keys) == 0 {
+		return errors.New("no API keys or validator configured")
+	}
+	for k := range o.claims {
+		if !o.keys[k] {
+			return errors.New("claims configured for an API key that is not in the key set")
+		}
 	}
+	return nil
 }
@@ -30,7 +30,12 @@ var _ engine.Authenticator = (*Authenticator)(nil)
 func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
 	auth := &Authenticator{options: &Options{}}
 	for _, o := range opts {
-		o(auth.options)
+		if err := o(auth.options); err != nil {
+			return nil, err
+		}
+	}
+	if err := auth.options.validate(); err != nil {
+		return nil, err
 	}
 	return auth, nil
 }
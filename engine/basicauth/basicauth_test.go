@@ -52,6 +52,23 @@ func TestNewAuthenticator_WithValidator(t *testing.T) {
 	require.NotNil(t, auth)
 }
 
+func TestNewAuthenticator_NoUsers(t *testing.T) {
+	_, err := NewAuthenticator()
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithUser("", "wonderland"))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithUser("alice", ""))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithUsers(map[string]string{"": "wonderland"}))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithUsers(map[string]string{"alice": "wonderland", "bob": ""}))
+	assert.NotNil(t, err)
+}
+
 // ---------------------------------------------------------------------------
 // AuthenticateToken
 // ---------------------------------------------------------------------------
@@ -1,5 +1,10 @@
 package basicauth
 
+import (
+	"errors"
+	"fmt"
+)
+
 // CredentialValidator is a callback that verifies whether the given
 // username/password pair is valid. Returning a non-nil AuthClaims allows
 // the caller to populate subject, roles, etc.
@@ -15,31 +20,61 @@ type Options struct {
 	validator CredentialValidator
 }
 
-type Option func(o *Options)
+type Option func(o *Options) error
 
 // WithUser adds a single username/password entry to the static credential map.
 // May be called multiple times.
 func WithUser(username, password string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
+		if err := validateUser(username, password); err != nil {
+			return err
+		}
 		if o.users == nil {
 			o.users = make(map[string]string)
 		}
 		o.users[username] = password
+		return nil
 	}
 }
 
 // WithUsers sets the entire static username→password map, replacing any
 // previously added entries.
 func WithUsers(users map[string]string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
+		for username, password := range users {
+			if err := validateUser(username, password); err != nil {
+				return err
+			}
+		}
 		o.users = users
+		return nil
 	}
 }
 
 // WithValidator sets a callback for verifying credentials against an
 // external source. When set, it takes precedence over the static map.
 func WithValidator(fn CredentialValidator) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		o.validator = fn
+		return nil
+	}
+}
+
+// validateUser rejects empty usernames and passwords.
+func validateUser(username, password string) error {
+	if username == "" {
+		return errors.New("username must not be empty")
+	}
+	if password == "" {
+		return fmt.Errorf("empty password for user %q", username)
+	}
+	return nil
+}
+
+// validate checks that credentials can be verified.
+func (o *Options) validate() error {
+	if o.validator == nil && len(o.users) == 0 {
+		return errors.New("no users or validator configured")
 	}
+	return nil
 }
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"math"
+	"strings"
 	"time"
 
 	"encoding/json"
@@ -10,13 +11,15 @@ import (
 )
 
 const (
-	ClaimFieldIssuer         = "iss" // 代表 JWT 的签发者。它是一个字符串或者 URL，用于标识是哪个实体（如服务器、服务提供商等）签发了这个 JWT。
-	ClaimFieldSubject        = "sub" // 代表 JWT 的主题。通常是一个唯一标识符，用于标识 JWT 所涉及的主体，这个主体通常是用户，但也可以是其他实体，如设备等。
-	ClaimFieldAudience       = "aud" // 代表 JWT 的受众。它指定了 JWT 的接收方，是一个或多个字符串或者 URL。
-	ClaimFieldExpirationTime = "exp" // 代表 JWT 的过期时间。它是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始到过期时间的秒数。
-	ClaimFieldNotBefore      = "nbf" // 代表 JWT 的生效时间。和exp类似，它是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始到生效时间的秒数。
-	ClaimFieldIssuedAt       = "iat" // 代表 JWT 的签发时间。也是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始到签发时间的秒数。
-	ClaimFieldJwtID          = "jti" // 代表 JWT 的唯一标识符。是一个字符串，用于唯一标识一个 JWT。
+	ClaimFieldIssuer         = "iss"       // 代表 JWT 的签发者。它是一个字符串或者 URL，用于标识是哪个实体（如服务器、服务提供商等）签发了这个 JWT。
+	ClaimFieldSubject        = "sub"       // 代表 JWT 的主题。通常是一个唯一标识符，用于标识 JWT 所涉及的主体，这个主体通常是用户，但也可以是其他实体，如设备等。
+	ClaimFieldAudience       = "aud"       // 代表 JWT 的受众。它指定了 JWT 的接收方，是一个或多个字符串或者 URL。
+	ClaimFieldExpirationTime = "exp"       // 代表 JWT 的过期时间。它是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始到过期时间的秒数。
+	ClaimFieldNotBefore      = "nbf"       // 代表 JWT 的生效时间。和exp类似，它是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始到生效时间的秒数。
+	ClaimFieldIssuedAt       = "iat"       // 代表 JWT 的签发时间。也是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始到签发时间的秒数。
+	ClaimFieldJwtID          = "jti"       // 代表 JWT 的唯一标识符。是一个字符串，用于唯一标识一个 JWT。
+	ClaimFieldClientID       = "client_id" // 代表请求令牌的 OAuth 2.0 客户端标识符。见 RFC 9068。
+	ClaimFieldAuthTime       = "auth_time" // 代表用户完成认证的时间。也是一个数字，表示从 1970 年 1 月 1 日 00:00:00 UTC 开始的秒数，用于计算会话的起点。
 
 	ClaimFieldScope = "scope" // 代表 JWT 的权限范围。它是一个字符串或者字符串数组，用于标识 JWT 的权限范围。在一个 API 访问场景中，scope的值可能是["read:users", "write:posts"]。这意味着拥有此 JWT 的用户被授权读取用户信息和写入文章相关内容。通过这种方式，scope清晰地界定了用户凭借该令牌可以进行的操作范围。
 )
@@ -63,12 +66,21 @@ func (c *AuthClaims) GetSubject() (string, error) {
 	return c.parseString(ClaimFieldSubject)
 }
 
-// GetScopes returns the scopes of the token.
+// GetScopes returns the scopes of the token. The claim may be an array of
+// strings or, as in RFC 9068 access tokens, a space-separated string.
 // Scopes see: https://datatracker.ietf.org/doc/html/rfc6749#section-3.3
 func (c *AuthClaims) GetScopes() (jwtV5.ClaimStrings, error) {
+	if v, ok := (*c)[ClaimFieldScope].(string); ok {
+		return strings.Fields(v), nil
+	}
 	return c.parseClaimsString(ClaimFieldScope)
 }
 
+// GetClientID returns the "client_id" claim.
+func (c *AuthClaims) GetClientID() (string, error) {
+	return c.parseString(ClaimFieldClientID)
+}
+
 func (c *AuthClaims) GetString(key string) (string, error) {
 	return c.parseString(key)
 }
@@ -72,3 +72,30 @@ func TestParseNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthClaimsGetScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope interface{}
+		want  []string
+	}{
+		{"space separated string", "read:users  write:posts", []string{"read:users", "write:posts"}},
+		{"single string", "openid", []string{"openid"}},
+		{"string array", []string{"read:users", "write:posts"}, []string{"read:users", "write:posts"}},
+		{"interface array", []interface{}{"read:users", "write:posts"}, []string{"read:users", "write:posts"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := AuthClaims{ClaimFieldScope: tt.scope}
+			got, err := claims.GetScopes()
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, []string(got))
+		})
+	}
+
+	claims := AuthClaims{}
+	got, err := claims.GetScopes()
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+}
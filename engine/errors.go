@@ -28,9 +28,15 @@ const (
 	AuthErrorCodeMissingKeyFunc           AuthErrorCode = 1014
 	AuthErrorCodeSignTokenFailed          AuthErrorCode = 1015
 	AuthErrorCodeGetKeyFailed             AuthErrorCode = 1016
+	AuthErrorCodeTokenRevoked             AuthErrorCode = 1017
+	AuthErrorCodeEncryptTokenFailed       AuthErrorCode = 1018
+	AuthErrorCodeDecryptTokenFailed       AuthErrorCode = 1019
+	AuthErrorCodeInvalidCertificate       AuthErrorCode = 1020
+	AuthErrorCodeProviderUnavailable      AuthErrorCode = 1021
 
 	AuthCodeNoAtHash      AuthErrorCode = 1050
 	AuthCodeInvalidAtHash AuthErrorCode = 1051
+	AuthCodeInvalidNonce  AuthErrorCode = 1052
 )
 
 var (
@@ -54,7 +60,13 @@ var (
 	ErrMissingKeyFunc           = status.Error(codes.Code(AuthErrorCodeMissingKeyFunc), "missing keyFunc")
 	ErrSignTokenFailed          = status.Error(codes.Code(AuthErrorCodeSignTokenFailed), "sign token failed")
 	ErrGetKeyFailed             = status.Error(codes.Code(AuthErrorCodeGetKeyFailed), "get key failed")
+	ErrTokenRevoked             = status.Error(codes.Code(AuthErrorCodeTokenRevoked), "token revoked")
+	ErrEncryptTokenFailed       = status.Error(codes.Code(AuthErrorCodeEncryptTokenFailed), "encrypt token failed")
+	ErrDecryptTokenFailed       = status.Error(codes.Code(AuthErrorCodeDecryptTokenFailed), "decrypt token failed")
+	ErrInvalidCertificate       = status.Error(codes.Code(AuthErrorCodeInvalidCertificate), "invalid certificate chain")
+	ErrProviderUnavailable      = status.Error(codes.Code(AuthErrorCodeProviderUnavailable), "identity provider unavailable")
 
 	ErrNoAtHash      = status.Error(codes.Code(AuthCodeNoAtHash), "id token did not have an access token hash")
 	ErrInvalidAtHash = status.Error(codes.Code(AuthCodeInvalidAtHash), "access token hash does not match value in ID token")
+	ErrInvalidNonce  = status.Error(codes.Code(AuthCodeInvalidNonce), "nonce does not match value in ID token")
 )
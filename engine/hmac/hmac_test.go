@@ -75,6 +75,22 @@ func TestNewAuthenticator_WithMaxSkew(t *testing.T) {
 	require.NotNil(t, auth)
 }
 
+func TestNewAuthenticator_NoSecrets(t *testing.T) {
+	_, err := NewAuthenticator()
+	assert.NotNil(t, err)
+}
+
+func TestNewAuthenticator_InvalidSecret(t *testing.T) {
+	_, err := NewAuthenticator(WithSecret("key.1", "super-secret"))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithSecret("key-1", ""))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithSecret("key-1", "s"), WithMaxSkew(-time.Minute))
+	assert.NotNil(t, err)
+}
+
 // ---------------------------------------------------------------------------
 // AuthenticateToken
 // ---------------------------------------------------------------------------
@@ -205,7 +221,7 @@ func TestCreateIdentity_NoSubject(t *testing.T) {
 }
 
 func TestCreateIdentity_NoSecret(t *testing.T) {
-	auth, _ := NewAuthenticator()
+	auth, _ := NewAuthenticator(WithSecret("key-2", "other-secret"))
 	claims := engine.AuthClaims{engine.ClaimFieldSubject: "key-1"}
 	_, err := auth.CreateIdentity(claims)
 	assert.NotNil(t, err)
@@ -1,6 +1,11 @@
 package hmac
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // SecretResolver returns the HMAC secret for the given key ID.
 // This allows key rotation and per-key secrets.
@@ -25,31 +30,72 @@ type Options struct {
 	signatureHeader string
 }
 
-type Option func(o *Options)
+type Option func(o *Options) error
 
 // WithSecret adds a keyID/secret pair to the static secret map.
 func WithSecret(keyID, secret string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
+		if err := validateSecret(keyID, secret); err != nil {
+			return err
+		}
 		if o.secrets == nil {
 			o.secrets = make(map[string]string)
 		}
 		o.secrets[keyID] = secret
+		return nil
 	}
 }
 
 // WithSecrets sets the entire static keyID→secret map.
 func WithSecrets(secrets map[string]string) Option {
-	return func(o *Options) { o.secrets = secrets }
+	return func(o *Options) error {
+		for keyID, secret := range secrets {
+			if err := validateSecret(keyID, secret); err != nil {
+				return err
+			}
+		}
+		o.secrets = secrets
+		return nil
+	}
 }
 
 // WithSecretResolver sets a callback for resolving secrets from an external source.
 func WithSecretResolver(fn SecretResolver) Option {
-	return func(o *Options) { o.resolver = fn }
+	return func(o *Options) error {
+		o.resolver = fn
+		return nil
+	}
 }
 
 // WithMaxSkew sets the maximum acceptable clock skew for timestamp validation.
 func WithMaxSkew(d time.Duration) Option {
-	return func(o *Options) { o.maxSkew = d }
+	return func(o *Options) error {
+		if d < 0 {
+			return errors.New("max skew must not be negative")
+		}
+		o.maxSkew = d
+		return nil
+	}
+}
+
+// validateSecret rejects key IDs that would break the "keyID.timestamp.signature"
+// token format, and empty secrets.
+func validateSecret(keyID, secret string) error {
+	if keyID == "" || strings.Contains(keyID, ".") {
+		return fmt.Errorf("invalid HMAC key ID %q", keyID)
+	}
+	if secret == "" {
+		return fmt.Errorf("empty secret for HMAC key ID %q", keyID)
+	}
+	return nil
+}
+
+// validate checks that secrets can be resolved.
+func (o *Options) validate() error {
+	if o.resolver == nil && len(o.secrets) == 0 {
+		return errors.New("no secrets or secret resolver configured")
+	}
+	return nil
 }
 
 func (o *Options) getSecret(keyID string) (string, bool) {
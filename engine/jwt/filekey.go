@@ -0,0 +1,209 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyReloadInterval is how often key files are checked for changes
+// when no interval is configured.
+const DefaultKeyReloadInterval = 10 * time.Second
+
+// KeyReloadFunc is called after a key file changed on disk. err is nil when
+// the new key was swapped in; otherwise the last good key stays active.
+type KeyReloadFunc func(path string, err error)
+
+// keyBox wraps a key so that keys of different dynamic types can be stored
+// in the same atomic.Value.
+type keyBox struct {
+	key interface{}
+}
+
+// fileKeySource holds a key parsed from a file and re-parses it when the file
+// content changes. Readers always see a complete key; a key that fails to
+// parse or check never replaces the last good one.
+type fileKeySource struct {
+	path  string
+	parse func([]byte) (interface{}, error)
+
+	// check, when set, validates a parsed key before it is swapped in.
+	check func(interface{}) error
+
+	key atomic.Value // keyBox
+
+	// digest is that of the active key, and badDigest and badErr those of
+	// the last content that failed to parse or check. They and lastErr are
+	// only touched by load, which runs on the constructor goroutine and then
+	// on the single watcher goroutine.
+	digest    [sha256.Size]byte
+	badDigest [sha256.Size]byte
+	badErr    error
+	lastErr   string
+}
+
+func newFileKeySource(path string, parse func([]byte) (interface{}, error)) (*fileKeySource, error) {
+	s := &fileKeySource{path: path, parse: parse}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Key returns the current key.
+func (s *fileKeySource) Key() interface{} {
+	box, _ := s.key.Load().(keyBox)
+	return box.key
+}
+
+// load re-reads the file and swaps in the key if the content changed.
+// changed reports whether the outcome differs from the last attempt, so a
+// file that is readable again after an error is reported as recovered even
+// if it still holds the active key.
+func (s *fileKeySource) load() (changed bool, err error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return s.fail(fmt.Errorf("read key file %s: %w", s.path, err))
+	}
+
+	digest := sha256.Sum256(data)
+	switch {
+	case digest == s.digest:
+		return s.succeed(), nil
+	case s.badErr != nil && digest == s.badDigest:
+		return s.fail(s.badErr)
+	}
+
+	key, err := s.parse(data)
+	if err != nil {
+		return s.failContent(digest, fmt.Errorf("parse key file %s: %w", s.path, err))
+	}
+	if s.check != nil {
+		if err = s.check(key); err != nil {
+			return s.failContent(digest, fmt.Errorf("key file %s: %w", s.path, err))
+		}
+	}
+
+	s.key.Store(keyBox{key: key})
+	s.digest = digest
+	s.badErr = nil
+	s.succeed()
+	return true, nil
+}
+
+// succeed clears the error state and reports whether there was one.
+func (s *fileKeySource) succeed() bool {
+	changed := s.lastErr != ""
+	s.lastErr = ""
+	return changed
+}
+
+// failContent remembers content that failed to parse or check, so that it
+// is not parsed again on every poll.
+func (s *fileKeySource) failContent(digest [sha256.Size]byte, err error) (bool, error) {
+	s.badDigest = digest
+	s.badErr = err
+	return s.fail(err)
+}
+
+// fail reports err as a change only the first time it occurs in a row, so a
+// persistently broken file is reported once rather than on every poll.
+func (s *fileKeySource) fail(err error) (bool, error) {
+	changed := err.Error() != s.lastErr
+	s.lastErr = err.Error()
+	return changed, err
+}
+
+// keyWatcher polls key files and reloads them on change.
+type keyWatcher struct {
+	sources  []*fileKeySource
+	interval time.Duration
+	onReload KeyReloadFunc
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newKeyWatcher(sources []*fileKeySource, interval time.Duration, onReload KeyReloadFunc) *keyWatcher {
+	if interval <= 0 {
+		interval = DefaultKeyReloadInterval
+	}
+	w := &keyWatcher{
+		sources:  sources,
+		interval: interval,
+		onReload: onReload,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *keyWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+func (w *keyWatcher) reload() {
+	for _, s := range w.sources {
+		changed, err := s.load()
+		if changed && w.onReload != nil {
+			w.onReload(s.path, err)
+		}
+	}
+}
+
+// Close stops the watcher and waits for it to exit.
+func (w *keyWatcher) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// parsePrivateKeyPEM parses a PEM-encoded RSA, ECDSA or Ed25519 private key.
+func parsePrivateKeyPEM(data []byte) (interface{}, error) {
+	if key, err := jwtV5.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwtV5.ParseECPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwtV5.ParseEdPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("no RSA, ECDSA or Ed25519 private key found")
+}
+
+// parsePublicKeyPEM parses a PEM-encoded RSA, ECDSA or Ed25519 public key,
+// or the public key of an X.509 certificate.
+func parsePublicKeyPEM(data []byte) (interface{}, error) {
+	if key, err := jwtV5.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwtV5.ParseECPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwtV5.ParseEdPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("no RSA, ECDSA or Ed25519 public key or certificate found")
+}
@@ -3,6 +3,7 @@ module github.com/tx7do/kratos-authn/engine/jwt
 go 1.25.0
 
 require (
+	github.com/go-jose/go-jose/v4 v4.1.5
 	github.com/go-kratos/kratos/v2 v2.9.2
 	github.com/golang-jwt/jwt/v5 v5.3.1
 	github.com/stretchr/testify v1.11.1
@@ -0,0 +1,21 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// Revoker is the interface that wraps the token revocation methods.
+type Revoker interface {
+	// Revoke revokes the token identified by jti until expiresAt.
+	Revoke(jti string, expiresAt time.Time) error
+
+	// RevokeClaims revokes the token the claims were parsed from, using
+	// its "jti" and "exp" claims.
+	RevokeClaims(claims *engine.AuthClaims) error
+
+	// RevokeSubject revokes every token of the subject issued before the
+	// given time ("log out everywhere").
+	RevokeSubject(subject string, before time.Time) error
+}
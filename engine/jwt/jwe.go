@@ -0,0 +1,156 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// JWE key management and content encryption algorithms supported for nested
+// (signed-then-encrypted) tokens.
+//
+// see: https://www.rfc-editor.org/rfc/rfc7518#section-4.1
+const (
+	KeyAlgorithmRSAOAEP256   = string(jose.RSA_OAEP_256)   // RSAES OAEP using SHA-256 and MGF1 with SHA-256
+	KeyAlgorithmECDHESA256KW = string(jose.ECDH_ES_A256KW) // ECDH-ES using Concat KDF and CEK wrapped with A256KW
+	KeyAlgorithmDirect       = string(jose.DIRECT)         // Direct use of a shared symmetric key as the CEK
+
+	ContentEncryptionA256GCM = string(jose.A256GCM) // AES GCM using 256-bit key
+)
+
+var supportedKeyAlgorithms = []jose.KeyAlgorithm{
+	jose.RSA_OAEP_256,
+	jose.ECDH_ES_A256KW,
+	jose.DIRECT,
+}
+
+var supportedContentEncryption = []jose.ContentEncryption{
+	jose.A256GCM,
+}
+
+// jweKey binds a key to the only key management algorithm it may be used with.
+type jweKey struct {
+	kid string
+	alg jose.KeyAlgorithm
+	key interface{}
+}
+
+// newEncryptionKey derives the key management algorithm from the type of the
+// recipient's public key.
+func newEncryptionKey(kid string, key interface{}) (*jweKey, error) {
+	var alg jose.KeyAlgorithm
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		alg = jose.RSA_OAEP_256
+	case *ecdsa.PublicKey:
+		alg = jose.ECDH_ES_A256KW
+	case []byte:
+		if len(k) != 32 {
+			return nil, errors.New("dir encryption with A256GCM requires a 32-byte key")
+		}
+		alg = jose.DIRECT
+	default:
+		return nil, fmt.Errorf("unsupported encryption key type %T", key)
+	}
+	return &jweKey{kid: kid, alg: alg, key: key}, nil
+}
+
+// newDecryptionKey derives the key management algorithm from the type of the
+// private key.
+func newDecryptionKey(kid string, key interface{}) (*jweKey, error) {
+	var alg jose.KeyAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		alg = jose.RSA_OAEP_256
+	case *ecdsa.PrivateKey:
+		alg = jose.ECDH_ES_A256KW
+	case []byte:
+		if len(k) != 32 {
+			return nil, errors.New("dir decryption with A256GCM requires a 32-byte key")
+		}
+		alg = jose.DIRECT
+	default:
+		return nil, fmt.Errorf("unsupported decryption key type %T", key)
+	}
+	return &jweKey{kid: kid, alg: alg, key: key}, nil
+}
+
+// isEncryptedToken reports whether the token is in JWE compact serialization
+// (five dot-separated parts) rather than JWS (three parts).
+func isEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// encryptToken wraps a signed token in a JWE with "cty": "JWT".
+func (a *Authenticator) encryptToken(signedToken string) (string, error) {
+	enc := a.options.encryptionKey
+
+	opts := (&jose.EncrypterOptions{}).WithContentType("JWT")
+
+	encrypter, err := jose.NewEncrypter(
+		jose.A256GCM,
+		jose.Recipient{Algorithm: enc.alg, Key: enc.key, KeyID: enc.kid},
+		opts,
+	)
+	if err != nil {
+		return "", engine.ErrEncryptTokenFailed
+	}
+
+	obj, err := encrypter.Encrypt([]byte(signedToken))
+	if err != nil {
+		return "", engine.ErrEncryptTokenFailed
+	}
+
+	strToken, err := obj.CompactSerialize()
+	if err != nil {
+		return "", engine.ErrEncryptTokenFailed
+	}
+
+	return strToken, nil
+}
+
+// decryptToken unwraps a nested JWE and returns the inner signed token.
+func (a *Authenticator) decryptToken(token string) (string, error) {
+	obj, err := jose.ParseEncryptedCompact(token, supportedKeyAlgorithms, supportedContentEncryption)
+	if err != nil {
+		return "", engine.ErrInvalidToken
+	}
+
+	key := a.lookupDecryptionKey(obj.Header.KeyID)
+	if key == nil || string(key.alg) != obj.Header.Algorithm {
+		return "", engine.ErrDecryptTokenFailed
+	}
+
+	// Only nested (signed-then-encrypted) JWTs are accepted.
+	if cty, _ := obj.Header.ExtraHeaders[jose.HeaderContentType].(string); !strings.EqualFold(cty, "JWT") {
+		return "", engine.ErrInvalidToken
+	}
+
+	plaintext, err := obj.Decrypt(key.key)
+	if err != nil {
+		return "", engine.ErrDecryptTokenFailed
+	}
+
+	return string(plaintext), nil
+}
+
+// lookupDecryptionKey finds the decryption key by "kid". A token without
+// "kid" falls back to the key configured without one, or to the only key.
+func (a *Authenticator) lookupDecryptionKey(kid string) *jweKey {
+	keys := a.options.decryptionKeys
+	if k, ok := keys[kid]; ok || kid != "" {
+		return k
+	}
+	if len(keys) == 1 {
+		for _, k := range keys {
+			return k
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package jwt
 import (
 	"context"
 	"errors"
+	"time"
 
 	jwtV5 "github.com/golang-jwt/jwt/v5"
 
@@ -10,9 +11,13 @@ import (
 )
 
 var _ engine.Authenticator = (*Authenticator)(nil)
+var _ Revoker = (*Authenticator)(nil)
 
 type Authenticator struct {
 	options *Options
+
+	// watcher reloads file-backed keys; nil when no key files are used.
+	watcher *keyWatcher
 }
 
 func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
@@ -21,11 +26,27 @@ func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
 	}
 
 	for _, o := range opts {
-		o(auth.options)
+		if err := o(auth.options); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := auth.options.validate(); err != nil {
+		return nil, err
+	}
+
+	if auth.options.revocationStore == nil {
+		// Subject cutoffs must outlive the tokens minted by this
+		// authenticator.
+		subjectTTL := DefaultSubjectRevocationTTL
+		if auth.options.ttl > subjectTTL {
+			subjectTTL = auth.options.ttl
+		}
+		auth.options.revocationStore = NewMemoryRevocationStore(subjectTTL)
 	}
 
-	if auth.options.signingMethod == nil {
-		auth.options.signingMethod = jwtV5.SigningMethodHS256
+	if sources := auth.options.keySources(); len(sources) > 0 {
+		auth.watcher = newKeyWatcher(sources, auth.options.keyReloadInterval, auth.options.onKeyReload)
 	}
 
 	return auth, nil
@@ -43,6 +64,26 @@ func (a *Authenticator) Authenticate(ctx context.Context, contextType engine.Con
 
 // AuthenticateToken authenticates the token string and returns the claims.
 func (a *Authenticator) AuthenticateToken(tokenString string) (*engine.AuthClaims, error) {
+	if isEncryptedToken(tokenString) {
+		var err error
+		if tokenString, err = a.decryptToken(tokenString); err != nil {
+			return nil, err
+		}
+	} else if a.options.requireEncryption {
+		return nil, engine.ErrInvalidToken
+	}
+
+	header, err := peekHeader(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !a.isAllowedAlgorithm(header.Alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+	if a.options.accessTokenProfile && !isAccessTokenType(header.Typ) {
+		return nil, engine.ErrInvalidToken
+	}
+
 	jwtToken, err := a.parseToken(tokenString)
 
 	if jwtToken == nil {
@@ -55,6 +96,8 @@ func (a *Authenticator) AuthenticateToken(tokenString string) (*engine.AuthClaim
 			return nil, engine.ErrInvalidToken
 		case errors.Is(err, jwtV5.ErrTokenSignatureInvalid):
 			return nil, engine.ErrSignTokenFailed
+		case errors.Is(err, engine.ErrInvalidCertificate):
+			return nil, engine.ErrInvalidCertificate
 		case errors.Is(err, jwtV5.ErrTokenExpired) || errors.Is(err, jwtV5.ErrTokenNotValidYet):
 			return nil, engine.ErrTokenExpired
 		default:
@@ -65,9 +108,6 @@ func (a *Authenticator) AuthenticateToken(tokenString string) (*engine.AuthClaim
 	if !jwtToken.Valid {
 		return nil, engine.ErrInvalidToken
 	}
-	if jwtToken.Method != a.options.signingMethod {
-		return nil, engine.ErrUnsupportedSigningMethod
-	}
 	if jwtToken.Claims == nil {
 		return nil, engine.ErrInvalidClaims
 	}
@@ -79,12 +119,22 @@ func (a *Authenticator) AuthenticateToken(tokenString string) (*engine.AuthClaim
 
 	authClaim := engine.AuthClaims(claims)
 
+	if a.options.accessTokenProfile {
+		if err = a.checkAccessTokenClaims(&authClaim); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = a.checkRevocation(&authClaim); err != nil {
+		return nil, err
+	}
+
 	return &authClaim, nil
 }
 
 // CreateIdentityWithContext creates a signed token string from the claims and sets it to the context.
 func (a *Authenticator) CreateIdentityWithContext(ctx context.Context, contextType engine.ContextType, claims engine.AuthClaims) (context.Context, error) {
-	strToken, err := a.CreateIdentity(claims)
+	strToken, err := a.createIdentity(ctx, claims)
 	if err != nil {
 		return ctx, err
 	}
@@ -96,37 +146,167 @@ func (a *Authenticator) CreateIdentityWithContext(ctx context.Context, contextTy
 
 // CreateIdentity creates a signed token string from the claims.
 func (a *Authenticator) CreateIdentity(claims engine.AuthClaims) (string, error) {
+	return a.createIdentity(context.Background(), claims)
+}
+
+func (a *Authenticator) createIdentity(ctx context.Context, claims engine.AuthClaims) (string, error) {
+	claims, err := a.mintClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	if a.options.accessTokenProfile {
+		if err = prepareAccessTokenClaims(claims); err != nil {
+			return "", err
+		}
+	}
+
 	jwtToken := jwtV5.NewWithClaims(
 		a.options.signingMethod,
 		&claims,
 	)
+	if a.options.accessTokenProfile {
+		jwtToken.Header["typ"] = AccessTokenType
+	}
 
-	strToken, err := a.generateToken(jwtToken)
+	strToken, err := a.generateToken(ctx, jwtToken)
 	if err != nil {
 		return "", err
 	}
 
+	if a.options.encryptionKey != nil {
+		return a.encryptToken(strToken)
+	}
+
 	return strToken, nil
 }
 
-func (a *Authenticator) Close() {}
+// Close stops reloading key files.
+func (a *Authenticator) Close() {
+	if a.watcher != nil {
+		a.watcher.Close()
+	}
+}
+
+// Revoke revokes the token identified by jti until expiresAt.
+func (a *Authenticator) Revoke(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return engine.ErrMissingJwtId
+	}
+	return a.options.revocationStore.Revoke(jti, expiresAt)
+}
+
+// RevokeClaims revokes the token the claims were parsed from.
+func (a *Authenticator) RevokeClaims(claims *engine.AuthClaims) error {
+	if claims == nil {
+		return engine.ErrInvalidClaims
+	}
+
+	jti, err := claims.GetJwtID()
+	if err != nil {
+		return engine.ErrInvalidJwtID
+	}
+
+	var expiresAt time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
+	}
+
+	return a.Revoke(jti, expiresAt)
+}
+
+// RevokeSubject revokes every token of the subject issued before the given
+// time. The cutoff is kept as long as the revocation store keeps it, which
+// must cover the longest lifetime of accepted tokens; the default store keeps
+// it for DefaultSubjectRevocationTTL, or the WithTTL lifetime if longer.
+//
+// "iat" only has a precision of seconds, so the cutoff is truncated to the
+// second: tokens issued within the second of the cutoff stay valid.
+func (a *Authenticator) RevokeSubject(subject string, before time.Time) error {
+	if subject == "" {
+		return engine.ErrInvalidSubject
+	}
+	return a.options.revocationStore.RevokeSubject(subject, before.Truncate(time.Second))
+}
+
+// checkRevocation rejects tokens whose jti is revoked, or whose subject was
+// revoked after the token was issued.
+func (a *Authenticator) checkRevocation(claims *engine.AuthClaims) error {
+	jti, err := claims.GetJwtID()
+	if err != nil {
+		return engine.ErrInvalidJwtID
+	}
+	if jti == "" && a.options.requireJTI {
+		return engine.ErrMissingJwtId
+	}
+
+	store := a.options.revocationStore
+
+	if jti != "" {
+		revoked, err := store.IsRevoked(jti)
+		if err != nil {
+			return engine.ErrUnauthenticated
+		}
+		if revoked {
+			return engine.ErrTokenRevoked
+		}
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil
+	}
+
+	before, ok, err := store.SubjectRevokedBefore(sub)
+	if err != nil {
+		return engine.ErrUnauthenticated
+	}
+	if !ok {
+		return nil
+	}
+
+	// A token without "iat" cannot prove it was issued after the cutoff.
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil || iat.Time.Before(before) {
+		return engine.ErrTokenRevoked
+	}
+
+	return nil
+}
 
 // parseToken parses the token string and returns the token.
 func (a *Authenticator) parseToken(token string) (*jwtV5.Token, error) {
-	if a.options.keyFunc == nil {
+	if len(a.options.verificationKeys) == 0 && a.options.x5cRoots == nil {
 		return nil, engine.ErrMissingKeyFunc
 	}
 
-	return jwtV5.Parse(token, a.options.keyFunc)
+	return jwtV5.Parse(token, a.keyFunc, jwtV5.WithValidMethods(a.options.allowedAlgorithms))
 }
 
 // generateToken generates a signed token string from the token.
-func (a *Authenticator) generateToken(jwtToken *jwtV5.Token) (string, error) {
-	if a.options.signingKey == nil {
+func (a *Authenticator) generateToken(ctx context.Context, jwtToken *jwtV5.Token) (string, error) {
+	if chain := a.options.signingChain; chain != nil {
+		jwtToken.Header[HeaderX5C] = encodeX5C(chain)
+		jwtToken.Header[HeaderX5TS256] = x5cThumbprint(chain[0])
+	}
+
+	if signer := a.options.signer; signer != nil {
+		if kid := signer.KeyID(); kid != "" {
+			jwtToken.Header["kid"] = kid
+		}
+		strToken, err := signWithSigner(ctx, jwtToken, signer)
+		if err != nil {
+			return "", engine.ErrSignTokenFailed
+		}
+		return strToken, nil
+	}
+
+	signingKey := a.options.currentSigningKey()
+	if signingKey == nil {
 		return "", engine.ErrMissingKeyFunc
 	}
 
-	strToken, err := jwtToken.SignedString(a.options.signingKey)
+	strToken, err := jwtToken.SignedString(signingKey)
 	if err != nil {
 		return "", engine.ErrSignTokenFailed
 	}
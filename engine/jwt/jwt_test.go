@@ -2,19 +2,27 @@ package jwt
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kratos/kratos/v2/transport"
+	jwtV5 "github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/tx7do/kratos-authn/engine"
@@ -404,3 +412,917 @@ func TestAuthenticatorEdDSA(t *testing.T) {
 	sub, _ := authToken.GetSubject()
 	assert.Equal(t, "user_name", sub)
 }
+
+func TestAuthenticatorRevocation(t *testing.T) {
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+	)
+	assert.Nil(t, err)
+
+	now := time.Now()
+
+	token, err := auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:        "user_name",
+		engine.ClaimFieldJwtID:          "token-1",
+		engine.ClaimFieldIssuedAt:       now.Unix(),
+		engine.ClaimFieldExpirationTime: now.Add(time.Hour).Unix(),
+	})
+	assert.Nil(t, err)
+
+	claims, err := auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+
+	revoker := auth.(Revoker)
+	assert.Nil(t, revoker.RevokeClaims(claims))
+
+	_, err = auth.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrTokenRevoked)
+}
+
+func TestAuthenticatorRevokeSubject(t *testing.T) {
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithRevocationStore(NewMemoryRevocationStore(time.Hour)),
+	)
+	assert.Nil(t, err)
+
+	now := time.Now()
+
+	oldToken, err := auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:  "user_name",
+		engine.ClaimFieldIssuedAt: now.Add(-time.Minute).Unix(),
+	})
+	assert.Nil(t, err)
+
+	otherToken, err := auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:  "other_user",
+		engine.ClaimFieldIssuedAt: now.Add(-time.Minute).Unix(),
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, auth.(Revoker).RevokeSubject("user_name", now))
+
+	_, err = auth.AuthenticateToken(oldToken)
+	assert.ErrorIs(t, err, engine.ErrTokenRevoked)
+
+	_, err = auth.AuthenticateToken(otherToken)
+	assert.Nil(t, err)
+
+	newToken, err := auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:  "user_name",
+		engine.ClaimFieldIssuedAt: now.Add(time.Second).Unix(),
+	})
+	assert.Nil(t, err)
+
+	_, err = auth.AuthenticateToken(newToken)
+	assert.Nil(t, err)
+}
+
+func TestAuthenticatorRevokeSubjectSameSecond(t *testing.T) {
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithRevocationStore(NewMemoryRevocationStore(time.Hour)),
+	)
+	assert.Nil(t, err)
+
+	// A cutoff within a second must not revoke a token issued in that
+	// second, whose "iat" is truncated to it.
+	now := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	assert.Nil(t, auth.(Revoker).RevokeSubject("user_name", now))
+
+	token, err := auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:  "user_name",
+		engine.ClaimFieldIssuedAt: now.Unix(),
+	})
+	assert.Nil(t, err)
+
+	_, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+
+	token, err = auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:  "user_name",
+		engine.ClaimFieldIssuedAt: now.Add(-time.Second).Unix(),
+	})
+	assert.Nil(t, err)
+
+	_, err = auth.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrTokenRevoked)
+}
+
+func TestAuthenticatorRequireJTI(t *testing.T) {
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithRequireJTI(true),
+	)
+	assert.Nil(t, err)
+
+	token, err := auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject: "user_name",
+	})
+	assert.Nil(t, err)
+
+	_, err = auth.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrMissingJwtId)
+
+	token, err = auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject: "user_name",
+		engine.ClaimFieldJwtID:   12345,
+	})
+	assert.Nil(t, err)
+
+	_, err = auth.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrInvalidJwtID)
+}
+
+func TestMemoryRevocationStoreExpiry(t *testing.T) {
+	store := NewMemoryRevocationStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	assert.Nil(t, store.Revoke("a", now.Add(time.Minute)))
+	assert.Nil(t, store.RevokeSubject("alice", now))
+
+	revoked, err := store.IsRevoked("a")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+
+	_, ok, err := store.SubjectRevokedBefore("alice")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	store.now = func() time.Time { return now.Add(2 * time.Minute) }
+
+	revoked, _ = store.IsRevoked("a")
+	assert.False(t, revoked)
+
+	_, ok, _ = store.SubjectRevokedBefore("alice")
+	assert.False(t, ok)
+}
+
+func TestMemoryRevocationStoreNoExpiry(t *testing.T) {
+	store := NewMemoryRevocationStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	// A token without "exp" stays revoked for good.
+	assert.Nil(t, store.Revoke("forever", time.Time{}))
+
+	store.now = func() time.Time { return now.Add(365 * 24 * time.Hour) }
+	assert.Nil(t, store.Revoke("other", now.Add(time.Minute)))
+
+	revoked, err := store.IsRevoked("forever")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+}
+
+func TestAuthenticatorRevokeClaimsNoExpiry(t *testing.T) {
+	auth, err := NewAuthenticator(WithKey([]byte("testKey")))
+	assert.Nil(t, err)
+
+	store := auth.(*Authenticator).options.revocationStore.(*MemoryRevocationStore)
+	now := time.Now()
+
+	claims := &engine.AuthClaims{engine.ClaimFieldSubject: "user_name", engine.ClaimFieldJwtID: "no-exp"}
+	assert.Nil(t, auth.(Revoker).RevokeClaims(claims))
+
+	store.now = func() time.Time { return now.Add(10 * DefaultSubjectRevocationTTL) }
+	revoked, err := store.IsRevoked("no-exp")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+
+	// Subject cutoffs of the default store outlive the minted tokens.
+	auth, err = NewAuthenticator(WithKey([]byte("testKey")), WithTTL(7*24*time.Hour))
+	assert.Nil(t, err)
+	store = auth.(*Authenticator).options.revocationStore.(*MemoryRevocationStore)
+	assert.Equal(t, 7*24*time.Hour, store.subjectTTL)
+}
+
+func TestAuthenticatorJWE(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	dirKey := make([]byte, 32)
+	_, err = rand.Read(dirKey)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		encryptKey interface{}
+		decryptKey interface{}
+	}{
+		{"RSA-OAEP-256", &rsaKey.PublicKey, rsaKey},
+		{"ECDH-ES+A256KW", &ecKey.PublicKey, ecKey},
+		{"dir", dirKey, dirKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewAuthenticator(
+				WithKey([]byte("test")),
+				WithSigningMethod("HS256"),
+				WithEncryptionKey("enc-1", tt.encryptKey),
+				WithDecryptionKey("enc-1", tt.decryptKey),
+				WithRequireEncryption(true),
+			)
+			assert.Nil(t, err)
+
+			token, err := auth.CreateIdentity(engine.AuthClaims{
+				engine.ClaimFieldSubject: "user_name",
+				"email":                  "user@example.com",
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 4, strings.Count(token, "."))
+
+			claims, err := auth.AuthenticateToken(token)
+			assert.Nil(t, err)
+
+			email, _ := claims.GetString("email")
+			assert.Equal(t, "user@example.com", email)
+		})
+	}
+}
+
+func TestAuthenticatorJWERejects(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithDecryptionKey("enc-1", rsaKey),
+		WithRequireEncryption(true),
+	)
+	assert.Nil(t, err)
+
+	plain, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+	)
+	assert.Nil(t, err)
+
+	principal := engine.AuthClaims{engine.ClaimFieldSubject: "user_name"}
+
+	token, err := plain.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrInvalidToken)
+
+	wrongRecipient, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithEncryptionKey("enc-1", &otherKey.PublicKey),
+	)
+	assert.Nil(t, err)
+
+	token, err = wrongRecipient.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrDecryptTokenFailed)
+
+	_, err = NewAuthenticator(WithDecryptionKey("enc-1", []byte("short")))
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticatorMintStandardClaims(t *testing.T) {
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithTTL(time.Hour),
+		WithIssuerClaim("https://issuer.example.com"),
+		WithDefaultAudience("api"),
+		WithJTIGenerator(RandomJTI),
+		WithNotBeforeSkew(30*time.Second),
+	)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	auth.(*Authenticator).options.now = func() time.Time { return now }
+
+	principal := engine.AuthClaims{
+		engine.ClaimFieldSubject: "user_name",
+	}
+
+	token, err := auth.CreateIdentity(principal)
+	assert.Nil(t, err)
+	assert.Len(t, principal, 1)
+
+	claims, err := auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+
+	exp, _ := claims.GetExpirationTime()
+	assert.Equal(t, now.Add(time.Hour).Unix(), exp.Unix())
+	iat, _ := claims.GetIssuedAt()
+	assert.Equal(t, now.Unix(), iat.Unix())
+	nbf, _ := claims.GetNotBefore()
+	assert.Equal(t, now.Add(-30*time.Second).Unix(), nbf.Unix())
+	iss, _ := claims.GetIssuer()
+	assert.Equal(t, "https://issuer.example.com", iss)
+	aud, _ := claims.GetAudience()
+	assert.Equal(t, []string{"api"}, []string(aud))
+	jti, _ := claims.GetJwtID()
+	assert.NotEmpty(t, jti)
+
+	// Claims set by the caller take precedence.
+	token, err = auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:        "user_name",
+		engine.ClaimFieldIssuer:         "custom",
+		engine.ClaimFieldJwtID:          "fixed",
+		engine.ClaimFieldExpirationTime: now.Add(time.Minute).Unix(),
+	})
+	assert.Nil(t, err)
+
+	claims, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+	iss, _ = claims.GetIssuer()
+	assert.Equal(t, "custom", iss)
+	jti, _ = claims.GetJwtID()
+	assert.Equal(t, "fixed", jti)
+	exp, _ = claims.GetExpirationTime()
+	assert.Equal(t, now.Add(time.Minute).Unix(), exp.Unix())
+}
+
+func TestAuthenticatorRequireExpiration(t *testing.T) {
+	auth, err := NewAuthenticator(
+		WithKey([]byte("test")),
+		WithSigningMethod("HS256"),
+		WithRequireExpiration(true),
+	)
+	assert.Nil(t, err)
+
+	_, err = auth.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user_name"})
+	assert.ErrorIs(t, err, engine.ErrInvalidExpiration)
+
+	_, err = auth.CreateIdentity(engine.AuthClaims{
+		engine.ClaimFieldSubject:        "user_name",
+		engine.ClaimFieldExpirationTime: time.Now().Add(time.Minute).Unix(),
+	})
+	assert.Nil(t, err)
+}
+
+func TestAuthenticatorAllowedAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	verifier, err := NewAuthenticator(
+		WithAllowedAlgorithms("RS256", "ES256"),
+		WithVerificationKeyForAlgorithms("rsa-1", &rsaKey.PublicKey, "RS256"),
+		WithVerificationKeyForAlgorithms("ec-1", &ecKey.PublicKey, "ES256"),
+	)
+	assert.Nil(t, err)
+
+	rsaSigner, err := NewAuthenticator(WithSigningMethod("RS256"), WithSigningKey(rsaKey))
+	assert.Nil(t, err)
+	ecSigner, err := NewAuthenticator(WithSigningMethod("ES256"), WithSigningKey(ecKey))
+	assert.Nil(t, err)
+	psSigner, err := NewAuthenticator(WithSigningMethod("PS256"), WithSigningKey(rsaKey))
+	assert.Nil(t, err)
+
+	principal := engine.AuthClaims{engine.ClaimFieldSubject: "user_name"}
+
+	for _, signer := range []engine.Authenticator{rsaSigner, ecSigner} {
+		token, err := signer.CreateIdentity(principal)
+		assert.Nil(t, err)
+		_, err = verifier.AuthenticateToken(token)
+		assert.Nil(t, err)
+	}
+
+	// PS256 is not allowed even though the RSA key could verify it.
+	token, err := psSigner.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = verifier.AuthenticateToken(token)
+	assert.ErrorIs(t, err, engine.ErrUnsupportedSigningMethod)
+}
+
+func TestAuthenticatorAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.Nil(t, err)
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyBytes})
+
+	verifier, err := NewAuthenticator(
+		WithAllowedAlgorithms("RS256", "HS256"),
+		WithPublicKeyFromPEM(publicKeyPEM),
+	)
+	assert.Nil(t, err)
+
+	// An attacker signs an HS256 token using the public key as HMAC secret.
+	forged, err := NewAuthenticator(WithKey(publicKeyPEM), WithSigningMethod("HS256"))
+	assert.Nil(t, err)
+	token, err := forged.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "admin"})
+	assert.Nil(t, err)
+
+	_, err = verifier.AuthenticateToken(token)
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticatorUnknownAlgorithm(t *testing.T) {
+	_, err := NewAuthenticator(WithKey([]byte("test")), WithSigningMethod("HS257"))
+	assert.ErrorIs(t, err, engine.ErrUnsupportedSigningMethod)
+
+	_, err = NewAuthenticator(WithKey([]byte("test")), WithAllowedAlgorithms("none"))
+	assert.ErrorIs(t, err, engine.ErrUnsupportedSigningMethod)
+
+	_, err = NewAuthenticator(WithVerificationKeyForAlgorithms("", []byte("test"), "XS256"))
+	assert.ErrorIs(t, err, engine.ErrUnsupportedSigningMethod)
+}
+
+func TestNewAuthenticatorConfigurationErrors(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	_, err = NewAuthenticator(WithSigningMethod("RS256"), WithPrivateKeyFromPEM([]byte("not a pem")))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithSigningMethod("ES256"), WithECPublicKeyFromPEM([]byte("not a pem")))
+	assert.NotNil(t, err)
+
+	// No key at all.
+	_, err = NewAuthenticator(WithSigningMethod("HS256"))
+	assert.NotNil(t, err)
+
+	// RSA key with an HMAC signing method.
+	_, err = NewAuthenticator(WithSigningMethod("HS256"), WithSigningKey(rsaKey))
+	assert.NotNil(t, err)
+
+	// RSA public key that can verify none of the allowed algorithms.
+	_, err = NewAuthenticator(WithSigningMethod("ES256"), WithVerificationKey(&rsaKey.PublicKey))
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticatorExternalSigner(t *testing.T) {
+	for _, alg := range []string{"RS256", "PS384", "ES256", "ES384", "ES512"} {
+		t.Run(alg, func(t *testing.T) {
+			signer, err := NewLocalSigner(alg, "kms-key-1")
+			assert.Nil(t, err)
+
+			auth, err := NewAuthenticator(
+				WithSigner(signer),
+				WithVerificationKeyForAlgorithms("kms-key-1", signer.Public(), alg),
+			)
+			assert.Nil(t, err)
+
+			token, err := auth.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user_name"})
+			assert.Nil(t, err)
+
+			header, err := peekHeader(token)
+			assert.Nil(t, err)
+			assert.Equal(t, alg, header.Alg)
+			assert.Equal(t, "kms-key-1", header.Kid)
+
+			claims, err := auth.AuthenticateToken(token)
+			assert.Nil(t, err)
+			sub, _ := claims.GetSubject()
+			assert.Equal(t, "user_name", sub)
+		})
+	}
+}
+
+// opaqueSigner hides the private key behind crypto.Signer, like a KMS binding.
+type opaqueSigner struct {
+	key crypto.Signer
+}
+
+func (s *opaqueSigner) Public() crypto.PublicKey { return s.key.Public() }
+
+func (s *opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestAuthenticatorCryptoSigner(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	auth, err := NewAuthenticator(
+		WithCryptoSigner(&opaqueSigner{key: privateKey}, "ES256", ""),
+		WithVerificationKey(&privateKey.PublicKey),
+	)
+	assert.Nil(t, err)
+
+	token, err := auth.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user_name"})
+	assert.Nil(t, err)
+
+	_, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+
+	// EdDSA signs whole messages and cannot be delegated as a digest.
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	_, err = NewAuthenticator(WithCryptoSigner(edKey, "EdDSA", ""))
+	assert.NotNil(t, err)
+
+	// The key type must match the algorithm.
+	_, err = NewAuthenticator(WithCryptoSigner(privateKey, "RS256", ""))
+	assert.NotNil(t, err)
+}
+
+// writeKeyFile replaces the file atomically, like a Kubernetes secret update.
+func writeKeyFile(t *testing.T, path string, data []byte) {
+	tmp := path + ".tmp"
+	assert.Nil(t, os.WriteFile(tmp, data, 0600))
+	assert.Nil(t, os.Rename(tmp, path))
+}
+
+func ecKeyPEMs(t *testing.T) (private, public []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	private = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	der, err = x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	public = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	return private, public
+}
+
+func TestAuthenticatorKeyFileReload(t *testing.T) {
+	dir := t.TempDir()
+	signingPath := filepath.Join(dir, "tls.key")
+	verifyPath := filepath.Join(dir, "tls.pub")
+
+	private1, public1 := ecKeyPEMs(t)
+	writeKeyFile(t, signingPath, private1)
+	writeKeyFile(t, verifyPath, public1)
+
+	type reload struct {
+		path string
+		err  error
+	}
+	reloads := make(chan reload, 16)
+	waitReload := func(path string) error {
+		for {
+			select {
+			case r := <-reloads:
+				if r.path == path {
+					return r.err
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no reload of %s", path)
+			}
+		}
+	}
+
+	auth, err := NewAuthenticator(
+		WithSigningMethod("ES256"),
+		WithSigningKeyFile(signingPath),
+		WithVerificationKeyFile("", verifyPath),
+		WithKeyReloadInterval(10*time.Millisecond),
+		WithKeyReloadCallback(func(path string, err error) {
+			reloads <- reload{path: path, err: err}
+		}),
+	)
+	assert.Nil(t, err)
+	defer auth.Close()
+
+	principal := engine.AuthClaims{engine.ClaimFieldSubject: "user_name"}
+
+	oldToken, err := auth.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(oldToken)
+	assert.Nil(t, err)
+
+	// Rotate the key pair.
+	private2, public2 := ecKeyPEMs(t)
+	writeKeyFile(t, signingPath, private2)
+	writeKeyFile(t, verifyPath, public2)
+	assert.Nil(t, waitReload(signingPath))
+	assert.Nil(t, waitReload(verifyPath))
+
+	_, err = auth.AuthenticateToken(oldToken)
+	assert.Equal(t, engine.ErrSignTokenFailed, err)
+
+	newToken, err := auth.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(newToken)
+	assert.Nil(t, err)
+
+	// A broken file is reported and the last good key stays in use.
+	writeKeyFile(t, signingPath, []byte("not a key"))
+	assert.NotNil(t, waitReload(signingPath))
+
+	token, err := auth.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+
+	// So is a key that does not fit the signing method.
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	writeKeyFile(t, signingPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	assert.NotNil(t, waitReload(signingPath))
+
+	token, err = auth.CreateIdentity(principal)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+}
+
+func TestFileKeySourceRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tls.key")
+	private, _ := ecKeyPEMs(t)
+	writeKeyFile(t, path, private)
+
+	source, err := newFileKeySource(path, parsePrivateKeyPEM)
+	assert.Nil(t, err)
+	key := source.Key()
+
+	changed, err := source.load()
+	assert.False(t, changed)
+	assert.Nil(t, err)
+
+	// A file that cannot be read is reported once,
+	assert.Nil(t, os.Remove(path))
+	changed, err = source.load()
+	assert.True(t, changed)
+	assert.NotNil(t, err)
+	changed, _ = source.load()
+	assert.False(t, changed)
+
+	// and its recovery too, even with the same key.
+	writeKeyFile(t, path, private)
+	changed, err = source.load()
+	assert.True(t, changed)
+	assert.Nil(t, err)
+	assert.Equal(t, key, source.Key())
+
+	// Broken content is reported again after a read error.
+	writeKeyFile(t, path, []byte("not a key"))
+	changed, err = source.load()
+	assert.True(t, changed)
+	assert.NotNil(t, err)
+	changed, _ = source.load()
+	assert.False(t, changed)
+
+	assert.Nil(t, os.Remove(path))
+	changed, _ = source.load()
+	assert.True(t, changed)
+	writeKeyFile(t, path, []byte("not a key"))
+	changed, err = source.load()
+	assert.True(t, changed)
+	assert.NotNil(t, err)
+	assert.Equal(t, key, source.Key())
+}
+
+func TestAuthenticatorKeyFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewAuthenticator(WithSigningKeyFile(filepath.Join(dir, "missing.key")))
+	assert.NotNil(t, err)
+
+	path := filepath.Join(dir, "tls.key")
+	writeKeyFile(t, path, []byte("not a key"))
+	_, err = NewAuthenticator(WithSigningKeyFile(path))
+	assert.NotNil(t, err)
+
+	// The initial key must fit the signing method.
+	private, _ := ecKeyPEMs(t)
+	writeKeyFile(t, path, private)
+	_, err = NewAuthenticator(WithSigningMethod("RS256"), WithSigningKeyFile(path))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithSigningKeyFile(path), WithSigningKey([]byte("secret")))
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticatorAccessTokenProfile(t *testing.T) {
+	key := []byte("secret")
+
+	auth, err := NewAuthenticator(
+		WithKey(key),
+		WithAccessTokenProfile(true),
+		WithTTL(time.Hour),
+		WithIssuerClaim("https://as.example.com"),
+		WithDefaultAudience("https://rs.example.com"),
+	)
+	assert.Nil(t, err)
+
+	principal := engine.AuthClaims{
+		engine.ClaimFieldSubject:  "user_name",
+		engine.ClaimFieldClientID: "client",
+		engine.ClaimFieldScope:    []string{"read:users", "write:posts"},
+	}
+
+	token, err := auth.CreateIdentity(principal)
+	assert.Nil(t, err)
+
+	header, err := peekHeader(token)
+	assert.Nil(t, err)
+	assert.Equal(t, AccessTokenType, header.Typ)
+
+	claims, err := auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "read:users write:posts", (*claims)[engine.ClaimFieldScope])
+
+	scopes, err := claims.GetScopes()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"read:users", "write:posts"}, []string(scopes))
+
+	clientID, err := claims.GetClientID()
+	assert.Nil(t, err)
+	assert.Equal(t, "client", clientID)
+
+	// Minting refuses tokens lacking a required claim.
+	_, err = auth.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user_name"})
+	assert.Equal(t, engine.ErrInvalidClaims, err)
+
+	// A plain JWT with otherwise valid claims is not an access token.
+	plain, err := NewAuthenticator(WithKey(key), WithTTL(time.Hour), WithJTIGenerator(RandomJTI))
+	assert.Nil(t, err)
+
+	now := time.Now().Unix()
+	full := engine.AuthClaims{
+		engine.ClaimFieldIssuer:   "https://as.example.com",
+		engine.ClaimFieldAudience: "https://rs.example.com",
+		engine.ClaimFieldSubject:  "user_name",
+		engine.ClaimFieldClientID: "client",
+		engine.ClaimFieldIssuedAt: now,
+	}
+	token, err = plain.CreateIdentity(full)
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrInvalidToken, err)
+
+	// Tokens minted by another profile authenticator are checked claim by claim.
+	tests := []struct {
+		name   string
+		modify func(c engine.AuthClaims)
+		want   error
+	}{
+		{"wrong issuer", func(c engine.AuthClaims) { c[engine.ClaimFieldIssuer] = "https://evil.example.com" }, engine.ErrInvalidIssuer},
+		{"wrong audience", func(c engine.AuthClaims) { c[engine.ClaimFieldAudience] = "https://other.example.com" }, engine.ErrInvalidAudience},
+		{"array scope", func(c engine.AuthClaims) { c[engine.ClaimFieldScope] = []interface{}{1} }, engine.ErrInvalidClaims},
+	}
+
+	issuer, err := NewAuthenticator(WithKey(key), WithAccessTokenProfile(true), WithTTL(time.Hour), WithDefaultAudience("https://rs.example.com"))
+	assert.Nil(t, err)
+
+	// The profile verifies "aud", so an audience is required.
+	_, err = NewAuthenticator(WithKey(key), WithAccessTokenProfile(true))
+	assert.NotNil(t, err)
+	_, err = NewAuthenticator(WithKey(key), WithAccessTokenProfile(true), WithDefaultAudience(""))
+	assert.NotNil(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := engine.AuthClaims{}
+			for k, v := range full {
+				c[k] = v
+			}
+			tt.modify(c)
+
+			token, err := issuer.CreateIdentity(c)
+			if err != nil {
+				assert.Equal(t, tt.want, err)
+				return
+			}
+			_, err = auth.AuthenticateToken(token)
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+// issueCertificate issues a certificate for key, signed by parent/parentKey,
+// or self-signed when parent is nil.
+func issueCertificate(t *testing.T, cn string, dnsNames []string, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert
+}
+
+func TestAuthenticatorX5C(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	ca := issueCertificate(t, "Partner Root CA", nil, caKey, nil, nil)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	leaf := issueCertificate(t, "partner", []string{"signer.partner.example.com"}, leafKey, ca, caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	partner, err := NewAuthenticator(
+		WithSigningMethod("ES256"),
+		WithSigningKey(leafKey),
+		WithSigningCertificateChain(leaf, ca),
+	)
+	assert.Nil(t, err)
+
+	token, err := partner.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user_name"})
+	assert.Nil(t, err)
+
+	header, _, err := jwtV5.NewParser().ParseUnverified(token, jwtV5.MapClaims{})
+	assert.Nil(t, err)
+	assert.Len(t, header.Header[HeaderX5C], 2)
+	assert.Equal(t, x5cThumbprint(leaf), header.Header[HeaderX5TS256])
+
+	auth, err := NewAuthenticator(
+		WithAllowedAlgorithms("ES256"),
+		WithX5CRoots(roots),
+		WithX5CAllowedNames("signer.partner.example.com"),
+	)
+	assert.Nil(t, err)
+
+	claims, err := auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user_name", sub)
+
+	// Pinned to another name.
+	pinned, err := NewAuthenticator(
+		WithAllowedAlgorithms("ES256"),
+		WithX5CRoots(roots),
+		WithX5CAllowedNames("other.example.com"),
+	)
+	assert.Nil(t, err)
+	_, err = pinned.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrInvalidCertificate, err)
+
+	// A chain that does not lead to the configured roots.
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(issueCertificate(t, "Other Root CA", nil, otherKey, nil, nil))
+
+	untrusted, err := NewAuthenticator(WithAllowedAlgorithms("ES256"), WithX5CRoots(otherRoots))
+	assert.Nil(t, err)
+	_, err = untrusted.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrInvalidCertificate, err)
+
+	// A certificate chain for a key other than the one that signed.
+	forger, err := NewAuthenticator(
+		WithSigningMethod("ES256"),
+		WithSigningKey(otherKey),
+		WithVerificationKey(&otherKey.PublicKey),
+	)
+	assert.Nil(t, err)
+	forgerAuth := forger.(*Authenticator)
+	forgerAuth.options.signingChain = []*x509.Certificate{leaf, ca}
+	token, err = forger.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user_name"})
+	assert.Nil(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrSignTokenFailed, err)
+}
+
+func TestAuthenticatorX5CConfigurationErrors(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	ca := issueCertificate(t, "Root CA", nil, caKey, nil, nil)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	leaf := issueCertificate(t, "leaf", nil, leafKey, ca, caKey)
+
+	// The leaf must certify the signing key.
+	_, err = NewAuthenticator(WithSigningMethod("ES256"), WithSigningKey(caKey), WithSigningCertificateChain(leaf))
+	assert.NotNil(t, err)
+
+	// The chain must be linked.
+	_, err = NewAuthenticator(WithSigningMethod("ES256"), WithSigningKey(leafKey), WithSigningCertificateChain(leaf, leaf))
+	assert.NotNil(t, err)
+
+	// A static chain cannot follow a rotating key file.
+	der, err := x509.MarshalECPrivateKey(leafKey)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "tls.key")
+	writeKeyFile(t, path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	_, err = NewAuthenticator(WithSigningMethod("ES256"), WithSigningKeyFile(path), WithSigningCertificateChain(leaf))
+	assert.NotNil(t, err)
+
+	// Name pinning needs roots.
+	_, err = NewAuthenticator(WithKey([]byte("secret")), WithX5CAllowedNames("leaf"))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithX5CRoots(nil))
+	assert.NotNil(t, err)
+}
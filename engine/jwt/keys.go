@@ -0,0 +1,180 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// verificationKey binds a verification key to the algorithms it may verify.
+type verificationKey struct {
+	kid  string
+	key  interface{}
+	algs []string // empty: every allowed algorithm compatible with the key type
+
+	// source, when set, supplies the key from a watched file instead of key.
+	source *fileKeySource
+}
+
+// current returns the key to verify with right now.
+func (k *verificationKey) current() interface{} {
+	if k.source != nil {
+		return k.source.Key()
+	}
+	return k.key
+}
+
+// allows reports whether the key may verify a token signed with alg.
+func (k *verificationKey) allows(alg string) bool {
+	return k.allowsKey(k.current(), alg)
+}
+
+// allowsKey reports whether key, bound like k, may verify a token signed
+// with alg. It is used to vet a reloaded key before it is swapped in.
+func (k *verificationKey) allowsKey(key interface{}, alg string) bool {
+	if !keyMatchesAlgorithm(key, alg) {
+		return false
+	}
+	if len(k.algs) == 0 {
+		return true
+	}
+	for _, a := range k.algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// keyMatchesAlgorithm reports whether the key type can be used with alg.
+// This is what prevents algorithm confusion, e.g. verifying an HS256 token
+// with an RSA public key used as the HMAC secret.
+func keyMatchesAlgorithm(key interface{}, alg string) bool {
+	switch k := key.(type) {
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return k.Curve == elliptic.P256()
+		case "ES384":
+			return k.Curve == elliptic.P384()
+		case "ES512":
+			return k.Curve == elliptic.P521()
+		}
+		return false
+	case ed25519.PublicKey:
+		return alg == jwtV5.SigningMethodEdDSA.Alg()
+	default:
+		return false
+	}
+}
+
+// signingKeyMatchesMethod reports whether the private key type can sign with
+// the method.
+func signingKeyMatchesMethod(key interface{}, method jwtV5.SigningMethod) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return keyMatchesAlgorithm(&k.PublicKey, method.Alg())
+	case *ecdsa.PrivateKey:
+		return keyMatchesAlgorithm(&k.PublicKey, method.Alg())
+	case ed25519.PrivateKey:
+		return method.Alg() == jwtV5.SigningMethodEdDSA.Alg()
+	default:
+		return keyMatchesAlgorithm(key, method.Alg())
+	}
+}
+
+// lookupSigningMethod resolves an algorithm name. "none" and unknown names
+// are configuration errors.
+func lookupSigningMethod(alg string) (jwtV5.SigningMethod, error) {
+	method := jwtV5.GetSigningMethod(alg)
+	if method == nil || method == jwtV5.SigningMethodNone {
+		return nil, fmt.Errorf("%w: %q", engine.ErrUnsupportedSigningMethod, alg)
+	}
+	return method, nil
+}
+
+// tokenHeader is the subset of the JOSE header inspected before verification.
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// peekHeader decodes the unverified header of a compact JWS.
+func peekHeader(token string) (*tokenHeader, error) {
+	seg, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, engine.ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return nil, engine.ErrInvalidToken
+	}
+	var h tokenHeader
+	if err = json.Unmarshal(raw, &h); err != nil {
+		return nil, engine.ErrInvalidToken
+	}
+	return &h, nil
+}
+
+// isAllowedAlgorithm reports whether alg is in the allowed list.
+func (a *Authenticator) isAllowedAlgorithm(alg string) bool {
+	for _, m := range a.options.allowedAlgorithms {
+		if m == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFunc selects the verification keys for the token by "kid" and
+// algorithm, or the verified "x5c" leaf key when x5c roots are configured.
+// A key is never handed to the parser for an algorithm it is not bound to.
+func (a *Authenticator) keyFunc(token *jwtV5.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if !a.isAllowedAlgorithm(alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+
+	if _, ok := token.Header[HeaderX5C]; ok && a.options.x5cRoots != nil {
+		return a.x5cKey(token, alg)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	var set jwtV5.VerificationKeySet
+	for _, k := range a.options.verificationKeys {
+		if kid != "" && k.kid != "" && k.kid != kid {
+			continue
+		}
+		// Load the key once so a concurrent reload cannot swap it between
+		// the check and its use.
+		key := k.current()
+		if !k.allowsKey(key, alg) {
+			continue
+		}
+		set.Keys = append(set.Keys, key)
+	}
+
+	switch len(set.Keys) {
+	case 0:
+		return nil, engine.ErrGetKeyFailed
+	case 1:
+		return set.Keys[0], nil
+	default:
+		return set, nil
+	}
+}
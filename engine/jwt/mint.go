@@ -0,0 +1,74 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// RandomJTI is a JTIGenerator returning a random 128-bit, base64url-encoded ID.
+func RandomJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// mintClaims returns a copy of claims with the standard claims configured
+// through the minting options filled in. Claims already set by the caller
+// are left untouched.
+func (a *Authenticator) mintClaims(claims engine.AuthClaims) (engine.AuthClaims, error) {
+	o := a.options
+
+	out := make(engine.AuthClaims, len(claims)+6)
+	for k, v := range claims {
+		out[k] = v
+	}
+
+	now := o.getNow()
+
+	setDefault := func(key string, value interface{}) {
+		if _, ok := out[key]; !ok {
+			out[key] = value
+		}
+	}
+
+	if o.ttl > 0 || o.mintNotBefore || o.accessTokenProfile {
+		setDefault(engine.ClaimFieldIssuedAt, now.Unix())
+	}
+	if o.ttl > 0 {
+		setDefault(engine.ClaimFieldExpirationTime, now.Add(o.ttl).Unix())
+	}
+	if o.mintNotBefore {
+		setDefault(engine.ClaimFieldNotBefore, now.Add(-o.notBeforeSkew).Unix())
+	}
+	if o.issuer != "" {
+		setDefault(engine.ClaimFieldIssuer, o.issuer)
+	}
+	switch len(o.audience) {
+	case 0:
+	case 1:
+		setDefault(engine.ClaimFieldAudience, o.audience[0])
+	default:
+		setDefault(engine.ClaimFieldAudience, append([]string(nil), o.audience...))
+	}
+	if o.jtiGenerator != nil {
+		if _, ok := out[engine.ClaimFieldJwtID]; !ok {
+			jti, err := o.jtiGenerator()
+			if err != nil {
+				return nil, engine.ErrSignTokenFailed
+			}
+			out[engine.ClaimFieldJwtID] = jti
+		}
+	}
+
+	if o.requireExpiration {
+		if _, ok := out[engine.ClaimFieldExpirationTime]; !ok {
+			return nil, engine.ErrInvalidExpiration
+		}
+	}
+
+	return out, nil
+}
@@ -1,32 +1,141 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
 	jwtV5 "github.com/golang-jwt/jwt/v5"
 )
 
 type Options struct {
-	signingMethod jwtV5.SigningMethod
-	signingKey    interface{}   // key for signing tokens (RSA private key for RS256)
-	keyFunc       jwtV5.Keyfunc // function to retrieve key for verifying tokens (RSA public key for RS256)
+	signingMethod    jwtV5.SigningMethod
+	signingKey       interface{}        // key for signing tokens (RSA private key for RS256)
+	signer           Signer             // external signer, used instead of signingKey
+	verificationKeys []*verificationKey // keys for verifying tokens (RSA public key for RS256)
+
+	// signingKeySource, when set, supplies the signing key from a watched file.
+	signingKeySource *fileKeySource
+	// keyReloadInterval is how often key files are checked for changes.
+	keyReloadInterval time.Duration
+	// onKeyReload is called after a key file changed on disk.
+	onKeyReload KeyReloadFunc
+
+	// allowedAlgorithms are the algorithms accepted when verifying tokens.
+	// Defaults to the signing method.
+	allowedAlgorithms []string
+
+	// revocationStore records revoked tokens. Defaults to an in-memory store.
+	revocationStore RevocationStore
+
+	// requireJTI rejects tokens without a "jti" claim.
+	requireJTI bool
+
+	// encryptionKey, when set, wraps minted tokens in a JWE for its recipient.
+	encryptionKey *jweKey
+	// decryptionKeys decrypt incoming JWE tokens, looked up by "kid".
+	decryptionKeys map[string]*jweKey
+	// requireEncryption rejects tokens that are not wrapped in a JWE.
+	requireEncryption bool
+
+	// ttl, when set, fills "exp" as issue time + ttl when minting.
+	ttl time.Duration
+	// issuer, when set, fills "iss" when minting.
+	issuer string
+	// audience, when set, fills "aud" when minting.
+	audience []string
+	// jtiGenerator, when set, fills "jti" when minting.
+	jtiGenerator JTIGenerator
+	// mintNotBefore fills "nbf" as issue time - notBeforeSkew when minting.
+	mintNotBefore bool
+	notBeforeSkew time.Duration
+	// requireExpiration refuses to mint tokens without "exp".
+	requireExpiration bool
+
+	// x5cRoots, when set, verifies tokens carrying an "x5c" certificate chain
+	// with the leaf key, provided the chain leads to one of these roots.
+	x5cRoots *x509.CertPool
+	// x5cNames pins the leaf certificate to one of these subject names.
+	x5cNames []string
+	// signingChain is emitted as "x5c" and "x5t#S256" when minting.
+	signingChain []*x509.Certificate
+
+	// accessTokenProfile enforces the JWT access token profile (RFC 9068)
+	// when minting and verifying tokens.
+	accessTokenProfile bool
+
+	// now returns the current time. Defaults to time.Now.
+	now func() time.Time
 }
 
-type Option func(d *Options)
+// JTIGenerator returns a new unique token ID for the "jti" claim.
+type JTIGenerator func() (string, error)
+
+// Option configures the authenticator. An option returns an error when its
+// argument is invalid, which makes NewAuthenticator fail.
+type Option func(o *Options) error
 
 // WithSigningMethod sets the signing method (e.g. "HS256", "RS256").
+// Unless WithAllowedAlgorithms is used, it is also the only algorithm
+// accepted when verifying tokens.
 func WithSigningMethod(alg string) Option {
-	return func(o *Options) {
-		o.signingMethod = jwtV5.GetSigningMethod(alg)
+	return func(o *Options) error {
+		method, err := lookupSigningMethod(alg)
+		if err != nil {
+			return err
+		}
+		o.signingMethod = method
+		return nil
+	}
+}
+
+// WithAllowedAlgorithms sets the algorithms accepted when verifying tokens
+// (e.g. "RS256", "ES256"). Tokens signed with any other algorithm are
+// rejected before their signature is checked.
+func WithAllowedAlgorithms(algs ...string) Option {
+	return func(o *Options) error {
+		for _, alg := range algs {
+			if _, err := lookupSigningMethod(alg); err != nil {
+				return err
+			}
+		}
+		o.allowedAlgorithms = append(o.allowedAlgorithms, algs...)
+		return nil
+	}
+}
+
+// WithVerificationKeyForAlgorithms adds a key for verifying tokens that is
+// bound to the given algorithms. When kid is not empty, the key is only used
+// for tokens whose "kid" header matches (or that carry no "kid").
+// May be called multiple times, e.g. for key rotation or to accept tokens
+// from several signers.
+func WithVerificationKeyForAlgorithms(kid string, key interface{}, algs ...string) Option {
+	return func(o *Options) error {
+		for _, alg := range algs {
+			if _, err := lookupSigningMethod(alg); err != nil {
+				return err
+			}
+			if !keyMatchesAlgorithm(key, alg) {
+				return fmt.Errorf("verification key of type %T cannot verify %s", key, alg)
+			}
+		}
+		o.verificationKeys = append(o.verificationKeys, &verificationKey{kid: kid, key: key, algs: algs})
+		return nil
 	}
 }
 
 // WithKey sets a single key used for both signing and verification.
 // Suitable for symmetric algorithms (e.g. HS256).
 func WithKey(key interface{}) Option {
-	return func(o *Options) {
-		o.signingKey = key
-		o.keyFunc = func(token *jwtV5.Token) (interface{}, error) {
-			return key, nil
+	return func(o *Options) error {
+		if key == nil {
+			return errors.New("key must not be nil")
 		}
+		o.signingKey = key
+		o.addVerificationKey(key)
+		return nil
 	}
 }
 
@@ -37,99 +146,529 @@ func WithKey(key interface{}) Option {
 //   - ECDSA (ES256/ES384/ES512): *ecdsa.PrivateKey
 //   - EdDSA: ed25519.PrivateKey
 func WithSigningKey(key interface{}) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
+		if key == nil {
+			return errors.New("signing key must not be nil")
+		}
 		o.signingKey = key
+		return nil
 	}
 }
 
-// WithVerificationKey sets the key for verifying tokens.
+// WithSigner signs tokens through an external Signer (KMS, HSM, agent)
+// instead of an in-memory key. The signing method is taken from the signer.
+// Pair it with WithVerificationKey(signer.Public()) to verify own tokens.
+func WithSigner(signer Signer) Option {
+	return func(o *Options) error {
+		if signer == nil {
+			return errors.New("signer must not be nil")
+		}
+		if _, err := signerHash(signer.Algorithm()); err != nil {
+			return err
+		}
+		method, err := lookupSigningMethod(signer.Algorithm())
+		if err != nil {
+			return err
+		}
+		o.signer = signer
+		o.signingMethod = method
+		return nil
+	}
+}
+
+// WithCryptoSigner signs tokens through any crypto.Signer, e.g. a PKCS#11
+// or cloud KMS binding. See NewCryptoSigner for the supported algorithms.
+func WithCryptoSigner(signer crypto.Signer, alg, kid string) Option {
+	return func(o *Options) error {
+		s, err := NewCryptoSigner(signer, alg, kid)
+		if err != nil {
+			return err
+		}
+		return WithSigner(s)(o)
+	}
+}
+
+// WithVerificationKey adds a key for verifying tokens. The key is used for
+// every allowed algorithm matching its type.
 // Supported types:
 //   - HMAC (HS256/HS384/HS512): []byte
 //   - RSA (RS256/RS384/RS512, PS256/PS384/PS512): *rsa.PublicKey
 //   - ECDSA (ES256/ES384/ES512): *ecdsa.PublicKey
 //   - EdDSA: ed25519.PublicKey
 func WithVerificationKey(key interface{}) Option {
-	return func(o *Options) {
-		o.keyFunc = func(token *jwtV5.Token) (interface{}, error) {
-			return key, nil
+	return func(o *Options) error {
+		if key == nil {
+			return errors.New("verification key must not be nil")
 		}
+		o.addVerificationKey(key)
+		return nil
 	}
 }
 
 // WithPrivateKeyFromPEM parses a PEM-encoded RSA private key and sets it for signing.
 // Suitable for RS256/RS384/RS512 and PS256/PS384/PS512.
 func WithPrivateKeyFromPEM(pemBytes []byte) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		key, err := jwtV5.ParseRSAPrivateKeyFromPEM(pemBytes)
 		if err != nil {
-			return
+			return fmt.Errorf("parse RSA private key: %w", err)
 		}
 		o.signingKey = key
+		return nil
 	}
 }
 
 // WithPublicKeyFromPEM parses a PEM-encoded RSA public key and sets it for verification.
 // Suitable for RS256/RS384/RS512 and PS256/PS384/PS512.
 func WithPublicKeyFromPEM(pemBytes []byte) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		key, err := jwtV5.ParseRSAPublicKeyFromPEM(pemBytes)
 		if err != nil {
-			return
-		}
-		o.keyFunc = func(token *jwtV5.Token) (interface{}, error) {
-			return key, nil
+			return fmt.Errorf("parse RSA public key: %w", err)
 		}
+		o.addVerificationKey(key)
+		return nil
 	}
 }
 
 // WithECPrivateKeyFromPEM parses a PEM-encoded ECDSA private key and sets it for signing.
 // Suitable for ES256/ES384/ES512.
 func WithECPrivateKeyFromPEM(pemBytes []byte) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		key, err := jwtV5.ParseECPrivateKeyFromPEM(pemBytes)
 		if err != nil {
-			return
+			return fmt.Errorf("parse EC private key: %w", err)
 		}
 		o.signingKey = key
+		return nil
 	}
 }
 
 // WithECPublicKeyFromPEM parses a PEM-encoded ECDSA public key and sets it for verification.
 // Suitable for ES256/ES384/ES512.
 func WithECPublicKeyFromPEM(pemBytes []byte) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		key, err := jwtV5.ParseECPublicKeyFromPEM(pemBytes)
 		if err != nil {
-			return
-		}
-		o.keyFunc = func(token *jwtV5.Token) (interface{}, error) {
-			return key, nil
+			return fmt.Errorf("parse EC public key: %w", err)
 		}
+		o.addVerificationKey(key)
+		return nil
 	}
 }
 
 // WithEd25519PrivateKeyFromPEM parses a PEM-encoded Ed25519 private key and sets it for signing.
 // Suitable for EdDSA.
 func WithEd25519PrivateKeyFromPEM(pemBytes []byte) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		key, err := jwtV5.ParseEdPrivateKeyFromPEM(pemBytes)
 		if err != nil {
-			return
+			return fmt.Errorf("parse Ed25519 private key: %w", err)
 		}
 		o.signingKey = key
+		return nil
 	}
 }
 
 // WithEd25519PublicKeyFromPEM parses a PEM-encoded Ed25519 public key and sets it for verification.
 // Suitable for EdDSA.
 func WithEd25519PublicKeyFromPEM(pemBytes []byte) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		key, err := jwtV5.ParseEdPublicKeyFromPEM(pemBytes)
 		if err != nil {
-			return
+			return fmt.Errorf("parse Ed25519 public key: %w", err)
+		}
+		o.addVerificationKey(key)
+		return nil
+	}
+}
+
+// WithSigningKeyFile loads the signing key from a PEM file (RSA, ECDSA or
+// Ed25519 private key) and reloads it whenever the file content changes,
+// e.g. when a Kubernetes secret or cert-manager certificate is rotated.
+// A key that fails to parse or does not fit the signing method is reported
+// through WithKeyReloadCallback and the last good key stays in use.
+func WithSigningKeyFile(path string) Option {
+	return func(o *Options) error {
+		source, err := newFileKeySource(path, parsePrivateKeyPEM)
+		if err != nil {
+			return err
 		}
-		o.keyFunc = func(token *jwtV5.Token) (interface{}, error) {
-			return key, nil
+		o.signingKeySource = source
+		return nil
+	}
+}
+
+// WithVerificationKeyFile adds a verification key loaded from a PEM file
+// (RSA, ECDSA or Ed25519 public key, or an X.509 certificate) and reloads it
+// whenever the file content changes. kid and algs bind the key like
+// WithVerificationKeyForAlgorithms; both may be empty.
+func WithVerificationKeyFile(kid, path string, algs ...string) Option {
+	return func(o *Options) error {
+		for _, alg := range algs {
+			if _, err := lookupSigningMethod(alg); err != nil {
+				return err
+			}
+		}
+		source, err := newFileKeySource(path, parsePublicKeyPEM)
+		if err != nil {
+			return err
+		}
+		o.verificationKeys = append(o.verificationKeys, &verificationKey{kid: kid, algs: algs, source: source})
+		return nil
+	}
+}
+
+// WithKeyReloadInterval sets how often key files are checked for changes.
+// Defaults to DefaultKeyReloadInterval.
+func WithKeyReloadInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return errors.New("key reload interval must be positive")
+		}
+		o.keyReloadInterval = interval
+		return nil
+	}
+}
+
+// WithKeyReloadCallback sets fn to be called after a key file changed, with
+// a nil error when the new key is in use, or the reason it was rejected.
+func WithKeyReloadCallback(fn KeyReloadFunc) Option {
+	return func(o *Options) error {
+		o.onKeyReload = fn
+		return nil
+	}
+}
+
+// WithRevocationStore sets the store used to look up revoked tokens.
+// Defaults to an in-memory store private to the authenticator.
+func WithRevocationStore(store RevocationStore) Option {
+	return func(o *Options) error {
+		if store == nil {
+			return errors.New("revocation store must not be nil")
+		}
+		o.revocationStore = store
+		return nil
+	}
+}
+
+// WithRequireJTI rejects tokens that carry no "jti" claim. Without a jti,
+// a token can only be revoked through RevokeSubject.
+func WithRequireJTI(require bool) Option {
+	return func(o *Options) error {
+		o.requireJTI = require
+		return nil
+	}
+}
+
+// WithEncryptionKey makes CreateIdentity wrap signed tokens in a JWE
+// (signed-then-encrypted, "cty": "JWT") using A256GCM content encryption.
+// The key management algorithm follows the recipient key type:
+//   - RSA-OAEP-256: *rsa.PublicKey
+//   - ECDH-ES+A256KW: *ecdsa.PublicKey
+//   - dir: []byte (32 bytes)
+//
+// kid is set in the JWE header so the recipient can select its key.
+func WithEncryptionKey(kid string, key interface{}) Option {
+	return func(o *Options) error {
+		k, err := newEncryptionKey(kid, key)
+		if err != nil {
+			return err
+		}
+		o.encryptionKey = k
+		return nil
+	}
+}
+
+// WithDecryptionKey adds a key for decrypting incoming JWE tokens, selected
+// by the "kid" JWE header. May be called multiple times for key rotation.
+// Supported types:
+//   - RSA-OAEP-256: *rsa.PrivateKey
+//   - ECDH-ES+A256KW: *ecdsa.PrivateKey
+//   - dir: []byte (32 bytes)
+func WithDecryptionKey(kid string, key interface{}) Option {
+	return func(o *Options) error {
+		k, err := newDecryptionKey(kid, key)
+		if err != nil {
+			return err
+		}
+		if o.decryptionKeys == nil {
+			o.decryptionKeys = make(map[string]*jweKey)
+		}
+		if _, ok := o.decryptionKeys[kid]; ok {
+			return fmt.Errorf("duplicate decryption key id %q", kid)
+		}
+		o.decryptionKeys[kid] = k
+		return nil
+	}
+}
+
+// WithRequireEncryption rejects tokens that are not wrapped in a JWE.
+func WithRequireEncryption(require bool) Option {
+	return func(o *Options) error {
+		o.requireEncryption = require
+		return nil
+	}
+}
+
+// WithTTL fills "exp" as the issue time plus ttl when minting tokens,
+// unless the claims already set it.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *Options) error {
+		if ttl < 0 {
+			return errors.New("ttl must not be negative")
+		}
+		o.ttl = ttl
+		return nil
+	}
+}
+
+// WithIssuerClaim fills "iss" when minting tokens, unless the claims already set it.
+func WithIssuerClaim(issuer string) Option {
+	return func(o *Options) error {
+		o.issuer = issuer
+		return nil
+	}
+}
+
+// WithDefaultAudience fills "aud" when minting tokens, unless the claims
+// already set it. A single audience is encoded as a string. With
+// WithAccessTokenProfile, it is also the audience verified tokens must have.
+func WithDefaultAudience(audience ...string) Option {
+	return func(o *Options) error {
+		for _, aud := range audience {
+			if aud == "" {
+				return errors.New("audience must not be empty")
+			}
+		}
+		o.audience = audience
+		return nil
+	}
+}
+
+// WithJTIGenerator fills "jti" from fn when minting tokens, unless the claims
+// already set it. Use RandomJTI for random 128-bit IDs.
+func WithJTIGenerator(fn JTIGenerator) Option {
+	return func(o *Options) error {
+		o.jtiGenerator = fn
+		return nil
+	}
+}
+
+// WithNotBeforeSkew fills "nbf" as the issue time minus skew when minting
+// tokens, unless the claims already set it. The skew tolerates verifiers
+// whose clocks run slightly behind.
+func WithNotBeforeSkew(skew time.Duration) Option {
+	return func(o *Options) error {
+		if skew < 0 {
+			return errors.New("not-before skew must not be negative")
+		}
+		o.mintNotBefore = true
+		o.notBeforeSkew = skew
+		return nil
+	}
+}
+
+// WithRequireExpiration makes CreateIdentity refuse to mint tokens that end
+// up without an "exp" claim.
+func WithRequireExpiration(require bool) Option {
+	return func(o *Options) error {
+		o.requireExpiration = require
+		return nil
+	}
+}
+
+// WithAccessTokenProfile enables the JWT profile for OAuth 2.0 access tokens
+// (RFC 9068). Verified tokens must carry the "at+jwt" type and the "iss",
+// "exp", "aud", "sub", "client_id", "iat" and "jti" claims, with "scope" as a
+// space-separated string. "aud" must contain one of the audiences set with
+// WithDefaultAudience, which is required. If WithIssuerClaim is set, "iss"
+// must match it.
+//
+// CreateIdentity emits the "at+jwt" type, joins array scopes into a string,
+// fills "iat" and "jti" (RandomJTI unless WithJTIGenerator is set) and
+// refuses to mint tokens lacking any other required claim.
+func WithAccessTokenProfile(enable bool) Option {
+	return func(o *Options) error {
+		o.accessTokenProfile = enable
+		return nil
+	}
+}
+
+// WithX5CRoots verifies tokens whose header carries an "x5c" certificate
+// chain: the chain must lead to one of the roots and be valid at the current
+// time, and the leaf certificate's key then verifies the signature. Tokens
+// without "x5c" are still verified with the configured keys.
+func WithX5CRoots(roots *x509.CertPool) Option {
+	return func(o *Options) error {
+		if roots == nil {
+			return errors.New("x5c root pool must not be nil")
+		}
+		o.x5cRoots = roots
+		return nil
+	}
+}
+
+// WithX5CAllowedNames pins "x5c" leaf certificates to the given names. A
+// certificate matches if a name equals its subject common name or one of its
+// DNS, URI or e-mail subject alternative names. Requires WithX5CRoots.
+func WithX5CAllowedNames(names ...string) Option {
+	return func(o *Options) error {
+		o.x5cNames = append(o.x5cNames, names...)
+		return nil
+	}
+}
+
+// WithSigningCertificateChain emits the chain (leaf first) as the "x5c"
+// header and the leaf thumbprint as "x5t#S256" when minting tokens. The leaf
+// must certify the signing key or signer. It cannot be combined with
+// WithSigningKeyFile, since the chain would not rotate with the key.
+func WithSigningCertificateChain(chain ...*x509.Certificate) Option {
+	return func(o *Options) error {
+		if err := validateCertificateChain(chain); err != nil {
+			return err
+		}
+		o.signingChain = chain
+		return nil
+	}
+}
+
+func (o *Options) addVerificationKey(key interface{}) {
+	o.verificationKeys = append(o.verificationKeys, &verificationKey{key: key})
+}
+
+// currentSigningKey returns the key to sign with right now.
+func (o *Options) currentSigningKey() interface{} {
+	if o.signingKeySource != nil {
+		return o.signingKeySource.Key()
+	}
+	return o.signingKey
+}
+
+// keySources returns the file-backed key sources to watch.
+func (o *Options) keySources() []*fileKeySource {
+	var sources []*fileKeySource
+	if o.signingKeySource != nil {
+		sources = append(sources, o.signingKeySource)
+	}
+	for _, k := range o.verificationKeys {
+		if k.source != nil {
+			sources = append(sources, k.source)
+		}
+	}
+	return sources
+}
+
+func (o *Options) getNow() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+// validate fills in defaults and checks that the configuration is complete
+// and consistent.
+func (o *Options) validate() error {
+	if o.signingMethod == nil {
+		o.signingMethod = jwtV5.SigningMethodHS256
+	}
+	if len(o.allowedAlgorithms) == 0 {
+		o.allowedAlgorithms = []string{o.signingMethod.Alg()}
+	}
+
+	if o.signingKey == nil && o.signingKeySource == nil && o.signer == nil && len(o.verificationKeys) == 0 && o.x5cRoots == nil {
+		return errors.New("no signing or verification key configured")
+	}
+
+	if len(o.x5cNames) > 0 && o.x5cRoots == nil {
+		return errors.New("x5c name pinning requires x5c roots")
+	}
+
+	if o.signingChain != nil {
+		if o.signingKeySource != nil {
+			return errors.New("signing certificate chain cannot be used with a signing key file")
+		}
+		if o.signingKey == nil && o.signer == nil {
+			return errors.New("signing certificate chain requires a signing key or signer")
+		}
+		if o.signer != nil {
+			if err := checkCertificateKey(o.signingChain, o.signer.Public()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if o.signingKey != nil && o.signingKeySource != nil {
+		return errors.New("signing key and signing key file are mutually exclusive")
+	}
+
+	if o.signer != nil {
+		if o.signingKey != nil || o.signingKeySource != nil {
+			return errors.New("signing key and signer are mutually exclusive")
+		}
+		if o.signer.Algorithm() != o.signingMethod.Alg() {
+			return fmt.Errorf("signing method %s does not match signer algorithm %s", o.signingMethod.Alg(), o.signer.Algorithm())
+		}
+	}
+
+	if o.signingKey != nil {
+		if err := o.checkSigningKey(o.signingKey); err != nil {
+			return err
+		}
+	}
+	if s := o.signingKeySource; s != nil {
+		if err := o.checkSigningKey(s.Key()); err != nil {
+			return fmt.Errorf("key file %s: %w", s.path, err)
+		}
+		s.check = o.checkSigningKey
+	}
+
+	for _, k := range o.verificationKeys {
+		if err := o.checkVerificationKey(k, k.current()); err != nil {
+			return err
+		}
+		if k.source != nil {
+			k := k
+			k.source.check = func(key interface{}) error {
+				return o.checkVerificationKey(k, key)
+			}
+		}
+	}
+
+	if o.accessTokenProfile && len(o.audience) == 0 {
+		return errors.New("access token profile requires an audience")
+	}
+
+	if o.accessTokenProfile && o.jtiGenerator == nil {
+		o.jtiGenerator = RandomJTI
+	}
+
+	if o.requireEncryption && len(o.decryptionKeys) == 0 {
+		return errors.New("encryption is required but no decryption key is configured")
+	}
+
+	return nil
+}
+
+// checkSigningKey checks that key can sign with the signing method.
+// When a signing certificate chain is set, its leaf must certify the key.
+func (o *Options) checkSigningKey(key interface{}) error {
+	if !signingKeyMatchesMethod(key, o.signingMethod) {
+		return fmt.Errorf("signing key of type %T cannot sign %s", key, o.signingMethod.Alg())
+	}
+	if o.signingChain != nil {
+		return checkCertificateKey(o.signingChain, signingPublicKey(key))
+	}
+	return nil
+}
+
+// checkVerificationKey checks that key, bound like k, can verify at least
+// one of the allowed algorithms.
+func (o *Options) checkVerificationKey(k *verificationKey, key interface{}) error {
+	for _, alg := range o.allowedAlgorithms {
+		if k.allowsKey(key, alg) {
+			return nil
 		}
 	}
+	return fmt.Errorf("verification key of type %T cannot verify any of the allowed algorithms %v", key, o.allowedAlgorithms)
 }
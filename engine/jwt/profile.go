@@ -0,0 +1,128 @@
+package jwt
+
+import (
+	"strings"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// AccessTokenType is the "typ" header of JWT access tokens.
+// see: https://www.rfc-editor.org/rfc/rfc9068#section-2.1
+const AccessTokenType = "at+jwt"
+
+// isAccessTokenType reports whether typ identifies a JWT access token. The
+// "application/" prefix may be omitted and the comparison ignores case.
+// see: https://www.rfc-editor.org/rfc/rfc7515#section-4.1.9
+func isAccessTokenType(typ string) bool {
+	typ = strings.ToLower(typ)
+	return strings.TrimPrefix(typ, "application/") == AccessTokenType
+}
+
+// requiredAccessTokenClaims are the claims every access token must carry,
+// with the error reported when one is missing.
+var requiredAccessTokenClaims = []struct {
+	name string
+	err  error
+}{
+	{engine.ClaimFieldIssuer, engine.ErrInvalidIssuer},
+	{engine.ClaimFieldExpirationTime, engine.ErrInvalidExpiration},
+	{engine.ClaimFieldAudience, engine.ErrInvalidAudience},
+	{engine.ClaimFieldSubject, engine.ErrInvalidSubject},
+	{engine.ClaimFieldClientID, engine.ErrInvalidClaims},
+	{engine.ClaimFieldIssuedAt, engine.ErrInvalidIssuedAt},
+	{engine.ClaimFieldJwtID, engine.ErrMissingJwtId},
+}
+
+// prepareAccessTokenClaims encodes "scope" as a space-separated string and
+// refuses to mint an access token that lacks a required claim.
+func prepareAccessTokenClaims(claims engine.AuthClaims) error {
+	if err := joinScopes(claims); err != nil {
+		return err
+	}
+	for _, c := range requiredAccessTokenClaims {
+		if v, ok := claims[c.name]; !ok || v == nil || v == "" {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// checkAccessTokenClaims checks the claims required by the JWT access token
+// profile. "aud" must contain one of the configured audiences, and when an
+// issuer is configured, "iss" must equal it.
+// see: https://www.rfc-editor.org/rfc/rfc9068#section-4
+func (a *Authenticator) checkAccessTokenClaims(claims *engine.AuthClaims) error {
+	o := a.options
+
+	iss, err := claims.GetIssuer()
+	if err != nil || iss == "" || (o.issuer != "" && iss != o.issuer) {
+		return engine.ErrInvalidIssuer
+	}
+
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return engine.ErrInvalidExpiration
+	}
+
+	aud, err := claims.GetAudience()
+	if err != nil || !audienceMatches(aud, o.audience) {
+		return engine.ErrInvalidAudience
+	}
+
+	if sub, err := claims.GetSubject(); err != nil || sub == "" {
+		return engine.ErrInvalidSubject
+	}
+
+	if clientID, err := claims.GetClientID(); err != nil || clientID == "" {
+		return engine.ErrInvalidClaims
+	}
+
+	if iat, err := claims.GetIssuedAt(); err != nil || iat == nil {
+		return engine.ErrInvalidIssuedAt
+	}
+
+	jti, err := claims.GetJwtID()
+	if err != nil {
+		return engine.ErrInvalidJwtID
+	}
+	if jti == "" {
+		return engine.ErrMissingJwtId
+	}
+
+	// "scope" is a space-separated string, not an array.
+	if scope, ok := (*claims)[engine.ClaimFieldScope]; ok {
+		if _, ok = scope.(string); !ok {
+			return engine.ErrInvalidClaims
+		}
+	}
+
+	return nil
+}
+
+// audienceMatches reports whether aud contains one of the expected
+// audiences.
+func audienceMatches(aud []string, expected []string) bool {
+	for _, a := range aud {
+		for _, e := range expected {
+			if a == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// joinScopes encodes an array "scope" claim as a space-separated string.
+func joinScopes(claims engine.AuthClaims) error {
+	if _, ok := claims[engine.ClaimFieldScope]; !ok {
+		return nil
+	}
+	if _, ok := claims[engine.ClaimFieldScope].(string); ok {
+		return nil
+	}
+	scopes, err := claims.GetScopes()
+	if err != nil {
+		return engine.ErrInvalidClaims
+	}
+	claims[engine.ClaimFieldScope] = strings.Join(scopes, " ")
+	return nil
+}
@@ -0,0 +1,132 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationStore is the interface for recording revoked tokens.
+// Implementations can use memory, Redis, a database, etc.
+//
+// Tokens are revoked individually by their "jti" claim, or in bulk for a
+// subject by recording a cutoff time: every token of that subject issued
+// before the cutoff is considered revoked ("log out everywhere").
+type RevocationStore interface {
+	// Revoke marks the token identified by jti as revoked. The entry only
+	// needs to be kept until expiresAt, after which the token is rejected
+	// by its "exp" claim anyway. A zero expiresAt is a token that never
+	// expires, whose entry must be kept for good.
+	Revoke(jti string, expiresAt time.Time) error
+
+	// IsRevoked reports whether the token identified by jti is revoked.
+	IsRevoked(jti string) (bool, error)
+
+	// RevokeSubject revokes every token of the subject issued before the
+	// given time. The cutoff must be kept at least as long as the longest
+	// lifetime of the tokens it covers.
+	RevokeSubject(subject string, before time.Time) error
+
+	// SubjectRevokedBefore returns the cutoff recorded for the subject.
+	// Returns (zero, false, nil) if no cutoff exists.
+	SubjectRevokedBefore(subject string) (time.Time, bool, error)
+}
+
+// DefaultSubjectRevocationTTL is how long MemoryRevocationStore keeps a
+// subject cutoff when no TTL is given. It must be at least as long as the
+// longest token lifetime in use: once the cutoff is dropped, tokens issued
+// before it are accepted again until they expire.
+const DefaultSubjectRevocationTTL = 24 * time.Hour
+
+// ---------------------------------------------------------------------------
+// MemoryRevocationStore — a thread-safe in-memory RevocationStore implementation.
+// ---------------------------------------------------------------------------
+
+type subjectCutoff struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// MemoryRevocationStore is an in-memory revocation store with per-entry TTL.
+// Expired entries are purged lazily on writes; entries of tokens without an
+// expiry are never purged.
+type MemoryRevocationStore struct {
+	mu         sync.RWMutex
+	tokens     map[string]time.Time
+	subjects   map[string]subjectCutoff
+	subjectTTL time.Duration
+
+	now func() time.Time
+}
+
+var _ RevocationStore = (*MemoryRevocationStore)(nil)
+
+// NewMemoryRevocationStore creates a new in-memory revocation store.
+// subjectTTL controls how long subject cutoffs are kept; if zero,
+// DefaultSubjectRevocationTTL is used. It must cover the longest lifetime
+// of accepted tokens; tokens without "exp" are only revoked for good by
+// their jti.
+func NewMemoryRevocationStore(subjectTTL time.Duration) *MemoryRevocationStore {
+	if subjectTTL <= 0 {
+		subjectTTL = DefaultSubjectRevocationTTL
+	}
+	return &MemoryRevocationStore{
+		tokens:     make(map[string]time.Time),
+		subjects:   make(map[string]subjectCutoff),
+		subjectTTL: subjectTTL,
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purgeLocked()
+	m.tokens[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	expiresAt, ok := m.tokens[jti]
+	if !ok {
+		return false, nil
+	}
+	return expiresAt.IsZero() || m.now().Before(expiresAt), nil
+}
+
+func (m *MemoryRevocationStore) RevokeSubject(subject string, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purgeLocked()
+	m.subjects[subject] = subjectCutoff{
+		before:    before,
+		expiresAt: before.Add(m.subjectTTL),
+	}
+	return nil
+}
+
+func (m *MemoryRevocationStore) SubjectRevokedBefore(subject string) (time.Time, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.subjects[subject]
+	if !ok || !m.now().Before(c.expiresAt) {
+		return time.Time{}, false, nil
+	}
+	return c.before, true, nil
+}
+
+// purgeLocked drops expired entries. The caller must hold the write lock.
+func (m *MemoryRevocationStore) purgeLocked() {
+	now := m.now()
+	for jti, expiresAt := range m.tokens {
+		if !expiresAt.IsZero() && !now.Before(expiresAt) {
+			delete(m.tokens, jti)
+		}
+	}
+	for sub, c := range m.subjects {
+		if !now.Before(c.expiresAt) {
+			delete(m.subjects, sub)
+		}
+	}
+}
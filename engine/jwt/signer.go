@@ -0,0 +1,193 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+)
+
+// Signer signs JWS digests with a key that does not have to live in process
+// memory, e.g. in a KMS, an HSM or a local agent.
+//
+// The authenticator builds the JWS signing input ("header.payload") itself,
+// hashes it with the algorithm's hash function and only hands the digest to
+// the signer.
+type Signer interface {
+	// Algorithm returns the JWS algorithm, e.g. "RS256", "PS256", "ES256".
+	Algorithm() string
+
+	// KeyID returns the "kid" header value, or "" to omit it.
+	KeyID() string
+
+	// Public returns the public key matching the signing key.
+	Public() crypto.PublicKey
+
+	// SignDigest signs the digest and returns the signature in JWS
+	// encoding (for ECDSA: the fixed-size R || S concatenation, not ASN.1).
+	SignDigest(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// signerHash returns the hash function for an algorithm usable with a Signer.
+// EdDSA is not supported because Ed25519 signs the message, not a digest.
+func signerHash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("unsupported signer algorithm %q", alg)
+	}
+	switch alg[:2] {
+	case "RS", "PS", "ES":
+	default:
+		return 0, fmt.Errorf("unsupported signer algorithm %q", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported signer algorithm %q", alg)
+	}
+}
+
+// signWithSigner produces a compact JWS for the token using the external signer.
+func signWithSigner(ctx context.Context, token *jwtV5.Token, signer Signer) (string, error) {
+	hash, err := signerHash(signer.Algorithm())
+	if err != nil {
+		return "", err
+	}
+
+	signingInput, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	h := hash.New()
+	h.Write([]byte(signingInput))
+
+	sig, err := signer.SignDigest(ctx, h.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + token.EncodeSegment(sig), nil
+}
+
+// ---------------------------------------------------------------------------
+// CryptoSigner — adapts a crypto.Signer to the Signer interface.
+// ---------------------------------------------------------------------------
+
+// CryptoSigner adapts any crypto.Signer (e.g. a PKCS#11 or cloud KMS binding)
+// to the Signer interface.
+type CryptoSigner struct {
+	signer crypto.Signer
+	alg    string
+	kid    string
+	hash   crypto.Hash
+}
+
+var _ Signer = (*CryptoSigner)(nil)
+
+// NewCryptoSigner wraps a crypto.Signer for the given JWS algorithm.
+// RSA keys support RS* and PS*, ECDSA keys support ES* on the matching curve.
+func NewCryptoSigner(signer crypto.Signer, alg, kid string) (*CryptoSigner, error) {
+	if signer == nil {
+		return nil, errors.New("crypto signer must not be nil")
+	}
+
+	hash, err := signerHash(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keyMatchesAlgorithm(signer.Public(), alg) {
+		return nil, fmt.Errorf("signer key of type %T cannot sign %s", signer.Public(), alg)
+	}
+
+	return &CryptoSigner{signer: signer, alg: alg, kid: kid, hash: hash}, nil
+}
+
+func (s *CryptoSigner) Algorithm() string { return s.alg }
+
+func (s *CryptoSigner) KeyID() string { return s.kid }
+
+func (s *CryptoSigner) Public() crypto.PublicKey { return s.signer.Public() }
+
+func (s *CryptoSigner) SignDigest(_ context.Context, digest []byte) ([]byte, error) {
+	var opts crypto.SignerOpts = s.hash
+	if strings.HasPrefix(s.alg, "PS") {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: s.hash}
+	}
+
+	sig, err := s.signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if pub, ok := s.signer.Public().(*ecdsa.PublicKey); ok {
+		return ecdsaASN1ToJWS(sig, pub.Curve)
+	}
+
+	return sig, nil
+}
+
+// ecdsaASN1ToJWS converts an ASN.1 DER ECDSA signature, as returned by
+// crypto.Signer, to the fixed-size R || S encoding required by JWS.
+func ecdsaASN1ToJWS(der []byte, curve elliptic.Curve) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// ---------------------------------------------------------------------------
+// LocalSigner — an in-process Signer for tests and development.
+// ---------------------------------------------------------------------------
+
+// NewLocalSigner generates a fresh key pair in process memory and returns a
+// Signer for it. It behaves like a remote signer (only digests go in, only
+// signatures come out) and is meant for tests and local development.
+// Supported algorithms: RS*, PS*, ES256, ES384, ES512.
+func NewLocalSigner(alg, kid string) (*CryptoSigner, error) {
+	if _, err := signerHash(alg); err != nil {
+		return nil, err
+	}
+
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch alg {
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	default:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCryptoSigner(key, alg, kid)
+}
@@ -0,0 +1,177 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// JWS header parameters carrying the signing certificate.
+// see: https://www.rfc-editor.org/rfc/rfc7515#section-4.1.6
+const (
+	HeaderX5C     = "x5c"      // certificate chain, leaf first, base64 (not URL) DER
+	HeaderX5TS256 = "x5t#S256" // base64url SHA-256 thumbprint of the leaf DER
+)
+
+// maxX5CChainLength bounds the number of certificates accepted in "x5c".
+const maxX5CChainLength = 10
+
+// x5cThumbprint returns the "x5t#S256" value of a certificate.
+func x5cThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// encodeX5C returns the "x5c" value of a certificate chain.
+func encodeX5C(chain []*x509.Certificate) []string {
+	out := make([]string, len(chain))
+	for i, cert := range chain {
+		out[i] = base64.StdEncoding.EncodeToString(cert.Raw)
+	}
+	return out
+}
+
+// parseX5C decodes the "x5c" header value, leaf first.
+func parseX5C(raw interface{}) ([]*x509.Certificate, error) {
+	values, ok := raw.([]interface{})
+	if !ok || len(values) == 0 || len(values) > maxX5CChainLength {
+		return nil, engine.ErrInvalidCertificate
+	}
+
+	chain := make([]*x509.Certificate, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, engine.ErrInvalidCertificate
+		}
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, engine.ErrInvalidCertificate
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, engine.ErrInvalidCertificate
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// certificateMatchesName reports whether name is the subject common name or
+// one of the DNS, URI or e-mail subject alternative names of cert.
+func certificateMatchesName(cert *x509.Certificate, name string) bool {
+	if cert.Subject.CommonName == name {
+		return true
+	}
+	for _, dns := range cert.DNSNames {
+		if dns == name {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == name {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if email == name {
+			return true
+		}
+	}
+	return false
+}
+
+// x5cKey verifies the "x5c" chain of the token against the configured roots
+// and returns the leaf public key for signature verification.
+func (a *Authenticator) x5cKey(token *jwtV5.Token, alg string) (interface{}, error) {
+	o := a.options
+
+	chain, err := parseX5C(token.Header[HeaderX5C])
+	if err != nil {
+		return nil, err
+	}
+	leaf := chain[0]
+
+	// If present, the thumbprint must describe the leaf in "x5c".
+	if raw, ok := token.Header[HeaderX5TS256]; ok {
+		if thumbprint, _ := raw.(string); thumbprint != x5cThumbprint(leaf) {
+			return nil, engine.ErrInvalidCertificate
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         o.x5cRoots,
+		Intermediates: intermediates,
+		CurrentTime:   o.getNow(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, engine.ErrInvalidCertificate
+	}
+
+	if len(o.x5cNames) > 0 {
+		pinned := false
+		for _, name := range o.x5cNames {
+			if certificateMatchesName(leaf, name) {
+				pinned = true
+				break
+			}
+		}
+		if !pinned {
+			return nil, engine.ErrInvalidCertificate
+		}
+	}
+
+	if !keyMatchesAlgorithm(leaf.PublicKey, alg) {
+		return nil, engine.ErrInvalidCertificate
+	}
+
+	return leaf.PublicKey, nil
+}
+
+// signingPublicKey returns the public key of a signing key, or nil if it has
+// none (e.g. an HMAC secret).
+func signingPublicKey(key interface{}) crypto.PublicKey {
+	if s, ok := key.(crypto.Signer); ok {
+		return s.Public()
+	}
+	return nil
+}
+
+// checkCertificateKey checks that the leaf of the signing certificate chain
+// certifies pub.
+func checkCertificateKey(chain []*x509.Certificate, pub crypto.PublicKey) error {
+	leafKey, ok := chain[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || pub == nil || !leafKey.Equal(pub) {
+		return errors.New("signing certificate does not match the signing key")
+	}
+	return nil
+}
+
+// validateCertificateChain checks that every certificate in the chain is
+// signed by the next one.
+func validateCertificateChain(chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("signing certificate chain must not be empty")
+	}
+	if len(chain) > maxX5CChainLength {
+		return fmt.Errorf("signing certificate chain exceeds %d certificates", maxX5CChainLength)
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return fmt.Errorf("signing certificate chain is broken at position %d: %w", i, err)
+		}
+	}
+	return nil
+}
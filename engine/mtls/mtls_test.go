@@ -42,6 +42,11 @@ func TestNewAuthenticator_WithValidator(t *testing.T) {
 	require.NotNil(t, auth)
 }
 
+func TestNewAuthenticator_EmptyTrustedCN(t *testing.T) {
+	_, err := NewAuthenticator(WithTrustedCN(""))
+	assert.NotNil(t, err)
+}
+
 // ---------------------------------------------------------------------------
 // Authenticate (via context)
 // ---------------------------------------------------------------------------
@@ -1,5 +1,7 @@
 package mtls
 
+import "errors"
+
 // CertValidator is a callback that inspects a client certificate's
 // subject (CN or SAN) and decides whether it should be accepted.
 // Returning true allows the certificate; false rejects it.
@@ -17,32 +19,43 @@ type Options struct {
 	validator CertValidator
 }
 
-type Option func(o *Options)
+type Option func(o *Options) error
 
 // WithTrustedCN adds a trusted Common Name / SAN to the static set.
 func WithTrustedCN(cn string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
+		if cn == "" {
+			return errors.New("trusted CN must not be empty")
+		}
 		if o.trustedCNs == nil {
 			o.trustedCNs = make(map[string]bool)
 		}
 		o.trustedCNs[cn] = true
+		return nil
 	}
 }
 
 // WithTrustedCNs sets the entire static set of trusted Common Names / SANs.
 func WithTrustedCNs(cns []string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		o.trustedCNs = make(map[string]bool)
 		for _, cn := range cns {
+			if cn == "" {
+				return errors.New("trusted CN must not be empty")
+			}
 			o.trustedCNs[cn] = true
 		}
+		return nil
 	}
 }
 
 // WithValidator sets a callback for validating certificates and returning
 // associated claims from an external source.
 func WithValidator(fn CertValidator) Option {
-	return func(o *Options) { o.validator = fn }
+	return func(o *Options) error {
+		o.validator = fn
+		return nil
+	}
 }
 
 func (o *Options) isTrusted(subject string) bool {
@@ -0,0 +1,176 @@
+package oauth2
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIntrospectionCacheSize is the default number of cached
+	// introspection results.
+	DefaultIntrospectionCacheSize = 10000
+
+	// DefaultIntrospectionNegativeTTL is how long an inactive result is
+	// cached by default.
+	DefaultIntrospectionNegativeTTL = 5 * time.Second
+)
+
+// cacheKey is the SHA-256 hash of a token, so that tokens are not kept in
+// memory in the clear.
+type cacheKey [sha256.Size]byte
+
+func tokenCacheKey(token string) cacheKey {
+	return sha256.Sum256([]byte(token))
+}
+
+// introspectionCache caches introspection results by token hash, evicting
+// the least recently used entry when full. Concurrent lookups of a token
+// that is not cached share one introspection request.
+type introspectionCache struct {
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	calls   map[cacheKey]*introspectionCall
+
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	key       cacheKey
+	resp      *introspectionResponse
+	expiresAt time.Time
+}
+
+// introspectionCall is an introspection request in flight.
+type introspectionCall struct {
+	done chan struct{}
+	resp *introspectionResponse
+	err  error
+
+	// forget is set if the token was revoked while in flight.
+	forget bool
+}
+
+func newIntrospectionCache(maxTTL, negativeTTL time.Duration, maxEntries int) *introspectionCache {
+	return &introspectionCache{
+		maxTTL:      maxTTL,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     make(map[cacheKey]*list.Element),
+		lru:         list.New(),
+		calls:       make(map[cacheKey]*introspectionCall),
+		now:         time.Now,
+	}
+}
+
+// do returns the cached result for key, or calls introspect and caches its
+// result. The request is not canceled with ctx while other lookups wait for
+// it; a lookup whose ctx ends stops waiting.
+func (c *introspectionCache) do(ctx context.Context, key cacheKey, introspect func(context.Context) (*introspectionResponse, error)) (*introspectionResponse, error) {
+	c.mu.Lock()
+	if resp, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return resp, nil
+	}
+
+	call, ok := c.calls[key]
+	if !ok {
+		call = &introspectionCall{done: make(chan struct{})}
+		c.calls[key] = call
+
+		go func() {
+			resp, err := introspect(context.WithoutCancel(ctx))
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			call.resp, call.err = resp, err
+			delete(c.calls, key)
+			if err == nil && !call.forget {
+				c.put(key, resp)
+			}
+			close(call.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.resp, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// get returns the cached result for key. c.mu must be held.
+func (c *introspectionCache) get(key cacheKey) (*introspectionResponse, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry.resp, true
+}
+
+// put caches resp until the token expires, for at most maxTTL, or for
+// negativeTTL if the token is inactive. c.mu must be held.
+func (c *introspectionCache) put(key cacheKey, resp *introspectionResponse) {
+	now := c.now()
+
+	var expiresAt time.Time
+	if resp.Active {
+		expiresAt = now.Add(c.maxTTL)
+		if resp.Exp > 0 {
+			if exp := time.Unix(resp.Exp, 0); exp.Before(expiresAt) {
+				expiresAt = exp
+			}
+		}
+	} else {
+		expiresAt = now.Add(c.negativeTTL)
+	}
+	if !now.Before(expiresAt) {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, resp: resp, expiresAt: expiresAt})
+}
+
+// remove drops the cached result for key, including that of a request in
+// flight.
+func (c *introspectionCache) remove(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call, ok := c.calls[key]; ok {
+		call.forget = true
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *introspectionCache) removeElement(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+}
@@ -0,0 +1,95 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	keyfuncV3 "github.com/MicahParks/keyfunc/v3"
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+)
+
+// IntrospectionJWTContentType is the media type of JWT introspection
+// responses, and the "typ" header of the JWT.
+const IntrospectionJWTContentType = "application/token-introspection+jwt"
+
+// DefaultJWTIntrospectionAlgorithms are the signing algorithms accepted for
+// JWT introspection responses unless set with WithJWTIntrospectionAlgorithms.
+var DefaultJWTIntrospectionAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// maxIntrospectionJWTSize bounds the size of a JWT introspection response.
+const maxIntrospectionJWTSize = 1 << 20
+
+// introspectionJWTClaims are the claims of a JWT introspection response,
+// RFC 9701 Section 5.
+type introspectionJWTClaims struct {
+	jwtV5.RegisteredClaims
+	TokenIntrospection map[string]json.RawMessage `json:"token_introspection"`
+}
+
+// setupJWTIntrospection sets the keyfunc for JWT introspection responses,
+// fetching the key set from the JWKS URL if one is configured.
+func (a *Authenticator) setupJWTIntrospection() error {
+	o := a.options
+	switch {
+	case o.jwtKeyfunc != nil:
+		a.jwtKeyfunc = o.jwtKeyfunc
+	case o.jwtJWKSURL != "":
+		jwks, err := keyfuncV3.NewDefaultOverrideCtx(a.ctx, []string{o.jwtJWKSURL}, keyfuncV3.Override{
+			Client: o.getHTTPClient(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create JWKS keyfunc: %w", err)
+		}
+		a.jwtKeyfunc = jwks.Keyfunc
+	}
+	return nil
+}
+
+// verifyIntrospectionJWT verifies a JWT introspection response, checking
+// its type, algorithm, signature, issuer, audience and issue time, and
+// returns the token_introspection claim.
+func (a *Authenticator) verifyIntrospectionJWT(resp *http.Response) (map[string]json.RawMessage, error) {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != IntrospectionJWTContentType {
+		return nil, fmt.Errorf("unexpected introspection response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIntrospectionJWTSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read introspection response: %w", err)
+	}
+
+	claims := &introspectionJWTClaims{}
+	token, err := jwtV5.ParseWithClaims(strings.TrimSpace(string(body)), claims, a.jwtKeyfunc,
+		jwtV5.WithValidMethods(a.options.jwtAlgorithms),
+		jwtV5.WithIssuer(a.options.jwtIssuer),
+		jwtV5.WithAudience(a.options.clientID),
+		jwtV5.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+
+	if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, "token-introspection+jwt") &&
+		!strings.EqualFold(typ, IntrospectionJWTContentType) {
+		return nil, fmt.Errorf("unexpected introspection response JWT type %q", typ)
+	}
+	if claims.IssuedAt == nil {
+		return nil, errors.New("introspection response has no iat")
+	}
+	if claims.TokenIntrospection == nil {
+		return nil, errors.New("introspection response has no token_introspection claim")
+	}
+
+	return claims.TokenIntrospection, nil
+}
@@ -8,6 +8,15 @@
 // On AuthenticateToken, the authenticator sends a POST request to the
 // introspection endpoint. If the response indicates the token is active,
 // the claims from the response (subject, username, scope, etc.) are returned.
+//
+// Introspection results can be cached with WithIntrospectionCache, so that
+// the endpoint is not asked on every request.
+//
+// With WithJWTIntrospection, the endpoint is asked for signed JWT responses
+// (RFC 9701), which are verified before they are trusted.
+//
+// Tokens can be revoked with Revoke, using the Token Revocation endpoint
+// (RFC 7009).
 package oauth2
 
 import (
@@ -20,9 +29,20 @@ import (
 	"net/url"
 	"strings"
 
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
 	"github.com/tx7do/kratos-authn/engine"
 )
 
+const (
+	// TokenTypeHintAccessToken hints that a token to revoke is an access
+	// token.
+	TokenTypeHintAccessToken = "access_token"
+	// TokenTypeHintRefreshToken hints that a token to revoke is a refresh
+	// token.
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
 // introspectionResponse is a subset of RFC 7662 Section 2.2.
 type introspectionResponse struct {
 	Active    bool   `json:"active"`
@@ -42,21 +62,49 @@ type introspectionResponse struct {
 // Authenticator validates tokens via OAuth2 Token Introspection.
 type Authenticator struct {
 	options *Options
+
+	// cache, when set, caches introspection results.
+	cache *introspectionCache
+
+	// jwtKeyfunc, when set, verifies JWT introspection responses.
+	jwtKeyfunc jwtV5.Keyfunc
+
+	// ctx ends the refresh of the JWT introspection key set on Close.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 var _ engine.Authenticator = (*Authenticator)(nil)
 
 // NewAuthenticator creates an OAuth2 introspection authenticator.
-// Returns an error if the introspection URL is not set.
+// Returns an error if the introspection URL is not set or the configuration
+// is inconsistent.
 func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
-	o := &Options{}
+	o := &Options{
+		cacheNegativeTTL: DefaultIntrospectionNegativeTTL,
+		jwtAlgorithms:    DefaultJWTIntrospectionAlgorithms,
+	}
 	for _, opt := range opts {
-		opt(o)
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{options: o}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	if o.cacheTTL > 0 {
+		a.cache = newIntrospectionCache(o.cacheTTL, o.cacheNegativeTTL, o.cacheSize)
 	}
-	if o.introspectURL == "" {
-		return nil, errors.New("introspection URL is required")
+
+	if err := a.setupJWTIntrospection(); err != nil {
+		a.cancel()
+		return nil, err
 	}
-	return &Authenticator{options: o}, nil
+	return a, nil
 }
 
 // Authenticate extracts the Bearer token from the incoming metadata and
@@ -66,13 +114,17 @@ func (a *Authenticator) Authenticate(ctx context.Context, contextType engine.Con
 	if err != nil {
 		return nil, engine.ErrMissingBearerToken
 	}
-	return a.AuthenticateToken(tokenString)
+	return a.authenticateToken(ctx, tokenString)
 }
 
 // AuthenticateToken sends the token to the introspection endpoint and
 // returns the claims if the token is active.
 func (a *Authenticator) AuthenticateToken(token string) (*engine.AuthClaims, error) {
-	resp, err := a.introspect(token)
+	return a.authenticateToken(context.Background(), token)
+}
+
+func (a *Authenticator) authenticateToken(ctx context.Context, token string) (*engine.AuthClaims, error) {
+	resp, err := a.lookup(ctx, token)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", engine.ErrInvalidToken, err)
 	}
@@ -105,14 +157,56 @@ func (a *Authenticator) CreateIdentity(claims engine.AuthClaims) (string, error)
 	return sub, nil
 }
 
-func (a *Authenticator) Close() {}
+// Close stops the refresh of the JWT introspection key set, if any.
+func (a *Authenticator) Close() {
+	a.cancel()
+}
+
+// Revoke sends the token to the RFC 7009 revocation endpoint and drops its
+// cached introspection result. hint, if not empty, is the token_type_hint.
+// The endpoint answers 200 for tokens that are invalid or already revoked,
+// so only failed requests are errors.
+func (a *Authenticator) Revoke(ctx context.Context, token, hint string) error {
+	if a.options.revocationURL == "" {
+		return errors.New("revocation URL is not configured")
+	}
+	if token == "" {
+		return errors.New("token is required")
+	}
+
+	// The token is forgotten locally even if the endpoint cannot be reached.
+	if a.cache != nil {
+		a.cache.remove(tokenCacheKey(token))
+	}
 
-// introspect sends a POST request to the RFC 7662 endpoint.
-func (a *Authenticator) introspect(token string) (*introspectionResponse, error) {
 	form := url.Values{}
 	form.Set("token", token)
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+
+	req, err := a.newFormRequest(ctx, a.options.revocationURL, form)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.options.getHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	req, err := http.NewRequest(http.MethodPost, a.options.introspectURL, strings.NewReader(form.Encode()))
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("revocation failed: HTTP %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// newFormRequest builds a POST request of form to endpoint, authenticated
+// with the client credentials.
+func (a *Authenticator) newFormRequest(ctx context.Context, endpoint string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -122,6 +216,31 @@ func (a *Authenticator) introspect(token string) (*introspectionResponse, error)
 	if a.options.clientID != "" || a.options.clientSecret != "" {
 		req.SetBasicAuth(a.options.clientID, a.options.clientSecret)
 	}
+	return req, nil
+}
+
+// lookup introspects the token, or returns its cached result.
+func (a *Authenticator) lookup(ctx context.Context, token string) (*introspectionResponse, error) {
+	if a.cache == nil {
+		return a.introspect(ctx, token)
+	}
+	return a.cache.do(ctx, tokenCacheKey(token), func(ctx context.Context) (*introspectionResponse, error) {
+		return a.introspect(ctx, token)
+	})
+}
+
+// introspect sends a POST request to the RFC 7662 endpoint.
+func (a *Authenticator) introspect(ctx context.Context, token string) (*introspectionResponse, error) {
+	form := url.Values{}
+	form.Set("token", token)
+
+	req, err := a.newFormRequest(ctx, a.options.introspectURL, form)
+	if err != nil {
+		return nil, err
+	}
+	if a.jwtKeyfunc != nil {
+		req.Header.Set("Accept", IntrospectionJWTContentType)
+	}
 
 	resp, err := a.options.getHTTPClient().Do(req)
 	if err != nil {
@@ -136,7 +255,11 @@ func (a *Authenticator) introspect(token string) (*introspectionResponse, error)
 
 	// Parse into a map first, then selectively extract fields.
 	var raw map[string]json.RawMessage
-	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+	if a.jwtKeyfunc != nil {
+		if raw, err = a.verifyIntrospectionJWT(resp); err != nil {
+			return nil, err
+		}
+	} else if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
 		return nil, fmt.Errorf("failed to decode introspection response: %w", err)
 	}
 
@@ -2,12 +2,20 @@ package oauth2
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	jwtV5 "github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/metadata"
@@ -85,6 +93,19 @@ func TestNewAuthenticator_WithClientCredentials(t *testing.T) {
 	require.NotNil(t, auth)
 }
 
+func TestNewAuthenticator_InvalidURL(t *testing.T) {
+	_, err := NewAuthenticator(WithIntrospectURL("localhost/introspect"))
+	assert.NotNil(t, err)
+}
+
+func TestNewAuthenticator_SecretWithoutClientID(t *testing.T) {
+	_, err := NewAuthenticator(
+		WithIntrospectURL("http://localhost/introspect"),
+		WithClientCredentials("", "csecret"),
+	)
+	assert.NotNil(t, err)
+}
+
 // ---------------------------------------------------------------------------
 // AuthenticateToken
 // ---------------------------------------------------------------------------
@@ -203,6 +224,469 @@ func TestAuthenticateToken_WithClientCredentials(t *testing.T) {
 	assert.True(t, strings.HasPrefix(capturedAuth, "Basic "))
 }
 
+// ---------------------------------------------------------------------------
+// Revoke
+// ---------------------------------------------------------------------------
+
+func TestRevoke_Success(t *testing.T) {
+	var capturedAuth, capturedToken, capturedHint string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedAuth = r.Header.Get("Authorization")
+		_ = r.ParseForm()
+		capturedToken = r.FormValue("token")
+		capturedHint = r.FormValue("token_type_hint")
+	}))
+	defer srv.Close()
+
+	auth, err := NewAuthenticator(
+		WithIntrospectURL(srv.URL),
+		WithRevocationURL(srv.URL),
+		WithClientCredentials("cid", "csecret"),
+	)
+	require.Nil(t, err)
+
+	err = auth.(*Authenticator).Revoke(context.Background(), "refresh-token", TokenTypeHintRefreshToken)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(capturedAuth, "Basic "))
+	assert.Equal(t, "refresh-token", capturedToken)
+	assert.Equal(t, TokenTypeHintRefreshToken, capturedHint)
+}
+
+func TestRevoke_UnsupportedTokenType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_token_type"})
+	}))
+	defer srv.Close()
+
+	auth, _ := NewAuthenticator(WithIntrospectURL(srv.URL), WithRevocationURL(srv.URL))
+	err := auth.(*Authenticator).Revoke(context.Background(), "token", "")
+	assert.NotNil(t, err)
+}
+
+func TestRevoke_NotConfigured(t *testing.T) {
+	auth, _ := NewAuthenticator(WithIntrospectURL("http://localhost/introspect"))
+	err := auth.(*Authenticator).Revoke(context.Background(), "token", "")
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(
+		WithIntrospectURL("http://localhost/introspect"),
+		WithRevocationURL("localhost/revoke"),
+	)
+	assert.NotNil(t, err)
+}
+
+// ---------------------------------------------------------------------------
+// Introspection cache
+// ---------------------------------------------------------------------------
+
+// newCountingIntrospectionServer answers like newMockIntrospectionServer and
+// counts the requests per token. Tokens named "exp-<unix>" are active until
+// the given time.
+func newCountingIntrospectionServer(requests *sync.Map) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		token := r.FormValue("token")
+
+		n, _ := requests.LoadOrStore(token, new(atomic.Int32))
+		n.(*atomic.Int32).Add(1)
+
+		switch {
+		case token == "expired-token":
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+		case strings.HasPrefix(token, "exp-"):
+			exp, _ := time.Parse(time.RFC3339, strings.TrimPrefix(token, "exp-"))
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": true, "sub": "user-123", "exp": exp.Unix()})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": true, "sub": "user-123", "exp": 9999999999})
+		}
+	}))
+}
+
+func requestCount(requests *sync.Map, token string) int32 {
+	n, ok := requests.Load(token)
+	if !ok {
+		return 0
+	}
+	return n.(*atomic.Int32).Load()
+}
+
+func TestIntrospectionCache_Hit(t *testing.T) {
+	var requests sync.Map
+	srv := newCountingIntrospectionServer(&requests)
+	defer srv.Close()
+
+	auth, err := NewAuthenticator(WithIntrospectURL(srv.URL), WithIntrospectionCache(time.Minute, 0))
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		claims, err := auth.AuthenticateToken("valid-token")
+		require.Nil(t, err)
+		sub, _ := claims.GetSubject()
+		assert.Equal(t, "user-123", sub)
+	}
+	assert.Equal(t, int32(1), requestCount(&requests, "valid-token"))
+
+	// Entries expire after the maximum TTL.
+	cache := auth.(*Authenticator).cache
+	cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, err = auth.AuthenticateToken("valid-token")
+	require.Nil(t, err)
+	assert.Equal(t, int32(2), requestCount(&requests, "valid-token"))
+}
+
+func TestIntrospectionCache_TokenExpiry(t *testing.T) {
+	var requests sync.Map
+	srv := newCountingIntrospectionServer(&requests)
+	defer srv.Close()
+
+	auth, _ := NewAuthenticator(WithIntrospectURL(srv.URL), WithIntrospectionCache(time.Hour, 0))
+	cache := auth.(*Authenticator).cache
+
+	// A token expiring before the maximum TTL is cached until it expires.
+	exp := time.Now().Add(time.Minute).Truncate(time.Second)
+	token := "exp-" + exp.Format(time.RFC3339)
+	_, err := auth.AuthenticateToken(token)
+	require.Nil(t, err)
+
+	cache.now = func() time.Time { return exp.Add(-time.Second) }
+	_, err = auth.AuthenticateToken(token)
+	require.Nil(t, err)
+	assert.Equal(t, int32(1), requestCount(&requests, token))
+
+	cache.now = func() time.Time { return exp }
+	_, _ = auth.AuthenticateToken(token)
+	assert.Equal(t, int32(2), requestCount(&requests, token))
+}
+
+func TestIntrospectionCache_Negative(t *testing.T) {
+	var requests sync.Map
+	srv := newCountingIntrospectionServer(&requests)
+	defer srv.Close()
+
+	auth, _ := NewAuthenticator(WithIntrospectURL(srv.URL), WithIntrospectionCache(time.Minute, 0))
+	cache := auth.(*Authenticator).cache
+
+	for i := 0; i < 2; i++ {
+		_, err := auth.AuthenticateToken("expired-token")
+		assert.Equal(t, engine.ErrUnauthenticated, err)
+	}
+	assert.Equal(t, int32(1), requestCount(&requests, "expired-token"))
+
+	cache.now = func() time.Time { return time.Now().Add(DefaultIntrospectionNegativeTTL) }
+	_, err := auth.AuthenticateToken("expired-token")
+	assert.Equal(t, engine.ErrUnauthenticated, err)
+	assert.Equal(t, int32(2), requestCount(&requests, "expired-token"))
+
+	// Negative caching can be disabled.
+	auth, _ = NewAuthenticator(
+		WithIntrospectURL(srv.URL),
+		WithIntrospectionCache(time.Minute, 0),
+		WithIntrospectionNegativeTTL(0),
+	)
+	for i := 0; i < 2; i++ {
+		_, _ = auth.AuthenticateToken("expired-token")
+	}
+	assert.Equal(t, int32(4), requestCount(&requests, "expired-token"))
+}
+
+func TestIntrospectionCache_Bounded(t *testing.T) {
+	var requests sync.Map
+	srv := newCountingIntrospectionServer(&requests)
+	defer srv.Close()
+
+	auth, _ := NewAuthenticator(WithIntrospectURL(srv.URL), WithIntrospectionCache(time.Minute, 2))
+
+	for _, token := range []string{"token-1", "token-2", "token-1", "token-3"} {
+		_, err := auth.AuthenticateToken(token)
+		require.Nil(t, err)
+	}
+	assert.Len(t, auth.(*Authenticator).cache.entries, 2)
+
+	// token-2 was the least recently used.
+	_, _ = auth.AuthenticateToken("token-1")
+	_, _ = auth.AuthenticateToken("token-2")
+	assert.Equal(t, int32(1), requestCount(&requests, "token-1"))
+	assert.Equal(t, int32(2), requestCount(&requests, "token-2"))
+}
+
+func TestIntrospectionCache_Concurrent(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": true, "sub": "user-123"})
+	}))
+	defer srv.Close()
+
+	auth, _ := NewAuthenticator(WithIntrospectURL(srv.URL), WithIntrospectionCache(time.Minute, 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := auth.AuthenticateToken("valid-token")
+			assert.Nil(t, err)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestIntrospectionCache_Revoke(t *testing.T) {
+	var requests sync.Map
+	srv := newCountingIntrospectionServer(&requests)
+	defer srv.Close()
+
+	auth, _ := NewAuthenticator(
+		WithIntrospectURL(srv.URL),
+		WithRevocationURL(srv.URL),
+		WithIntrospectionCache(time.Minute, 0),
+	)
+
+	_, err := auth.AuthenticateToken("valid-token")
+	require.Nil(t, err)
+	require.Nil(t, auth.(*Authenticator).Revoke(context.Background(), "valid-token", TokenTypeHintAccessToken))
+
+	_, err = auth.AuthenticateToken("valid-token")
+	require.Nil(t, err)
+	// One introspection before and one after the revocation request.
+	assert.Equal(t, int32(3), requestCount(&requests, "valid-token"))
+}
+
+func TestIntrospectionCache_InvalidOptions(t *testing.T) {
+	_, err := NewAuthenticator(WithIntrospectURL("http://localhost/introspect"), WithIntrospectionCache(0, 0))
+	assert.NotNil(t, err)
+	_, err = NewAuthenticator(WithIntrospectURL("http://localhost/introspect"), WithIntrospectionCache(time.Minute, -1))
+	assert.NotNil(t, err)
+	_, err = NewAuthenticator(WithIntrospectURL("http://localhost/introspect"), WithIntrospectionNegativeTTL(-time.Second))
+	assert.NotNil(t, err)
+}
+
+// ---------------------------------------------------------------------------
+// JWT introspection responses (RFC 9701)
+// ---------------------------------------------------------------------------
+
+const jwtIntrospectionIssuer = "https://idp.example.com"
+
+// newJWTIntrospectionServer answers with JWT introspection responses signed
+// by key. mutate, if set, changes the claims or header before signing.
+func newJWTIntrospectionServer(t *testing.T, key *rsa.PrivateKey, mutate func(jwtV5.MapClaims, map[string]interface{})) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, IntrospectionJWTContentType, r.Header.Get("Accept"))
+		_ = r.ParseForm()
+
+		introspection := map[string]interface{}{"active": false}
+		if r.FormValue("token") == "valid-token" {
+			introspection = map[string]interface{}{
+				"active": true,
+				"sub":    "user-123",
+				"scope":  "read write",
+				"custom": "custom-value",
+			}
+		}
+
+		claims := jwtV5.MapClaims{
+			"iss":                 jwtIntrospectionIssuer,
+			"aud":                 "cid",
+			"iat":                 time.Now().Unix(),
+			"token_introspection": introspection,
+		}
+		token := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, claims)
+		token.Header["typ"] = "token-introspection+jwt"
+		token.Header["kid"] = "1"
+		if mutate != nil {
+			mutate(claims, token.Header)
+		}
+
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", IntrospectionJWTContentType)
+		_, _ = w.Write([]byte(signed))
+	}))
+}
+
+func newJWTIntrospectionAuthenticator(t *testing.T, url string, key *rsa.PrivateKey) engine.Authenticator {
+	auth, err := NewAuthenticator(
+		WithIntrospectURL(url),
+		WithClientCredentials("cid", "csecret"),
+		WithExtraClaimsKeys("custom"),
+		WithJWTIntrospection(jwtIntrospectionIssuer, func(*jwtV5.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		}),
+	)
+	require.NoError(t, err)
+	return auth
+}
+
+func TestJWTIntrospection_Valid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	srv := newJWTIntrospectionServer(t, key, nil)
+	defer srv.Close()
+
+	auth := newJWTIntrospectionAuthenticator(t, srv.URL, key)
+	defer auth.Close()
+
+	claims, err := auth.AuthenticateToken("valid-token")
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user-123", sub)
+	scopes, _ := claims.GetScopes()
+	assert.Equal(t, []string{"read", "write"}, []string(scopes))
+	assert.Equal(t, "custom-value", (*claims)["custom"])
+
+	_, err = auth.AuthenticateToken("expired-token")
+	assert.Equal(t, engine.ErrUnauthenticated, err)
+}
+
+func TestJWTIntrospection_Invalid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	invalid := map[string]func(jwtV5.MapClaims, map[string]interface{}){
+		"other issuer":     func(c jwtV5.MapClaims, _ map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"other audience":   func(c jwtV5.MapClaims, _ map[string]interface{}) { c["aud"] = "other" },
+		"no iat":           func(c jwtV5.MapClaims, _ map[string]interface{}) { delete(c, "iat") },
+		"future iat":       func(c jwtV5.MapClaims, _ map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"no introspection": func(c jwtV5.MapClaims, _ map[string]interface{}) { delete(c, "token_introspection") },
+		"other type":       func(_ jwtV5.MapClaims, h map[string]interface{}) { h["typ"] = "JWT" },
+	}
+	for name, mutate := range invalid {
+		srv := newJWTIntrospectionServer(t, key, mutate)
+		auth := newJWTIntrospectionAuthenticator(t, srv.URL, key)
+		_, err = auth.AuthenticateToken("valid-token")
+		assert.ErrorIs(t, err, engine.ErrInvalidToken, name)
+		srv.Close()
+	}
+
+	// A response signed with another key is rejected.
+	srv := newJWTIntrospectionServer(t, otherKey, nil)
+	defer srv.Close()
+	auth := newJWTIntrospectionAuthenticator(t, srv.URL, key)
+	_, err = auth.AuthenticateToken("valid-token")
+	assert.ErrorIs(t, err, engine.ErrInvalidToken)
+
+	// Plain JSON responses are rejected once JWT responses are required.
+	plain := newMockIntrospectionServer()
+	defer plain.Close()
+	auth = newJWTIntrospectionAuthenticator(t, plain.URL, key)
+	_, err = auth.AuthenticateToken("valid-token")
+	assert.ErrorIs(t, err, engine.ErrInvalidToken)
+}
+
+func TestJWTIntrospection_Algorithms(t *testing.T) {
+	secret := []byte("shared-secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := jwtV5.NewWithClaims(jwtV5.SigningMethodHS256, jwtV5.MapClaims{
+			"iss":                 jwtIntrospectionIssuer,
+			"aud":                 "cid",
+			"iat":                 time.Now().Unix(),
+			"token_introspection": map[string]interface{}{"active": true, "sub": "user-123"},
+		})
+		token.Header["typ"] = "token-introspection+jwt"
+		signed, err := token.SignedString(secret)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", IntrospectionJWTContentType)
+		_, _ = w.Write([]byte(signed))
+	}))
+	defer srv.Close()
+
+	// A keyfunc that returns a shared secret does not enable symmetric
+	// algorithms by itself.
+	keyfunc := func(*jwtV5.Token) (interface{}, error) { return secret, nil }
+	auth, err := NewAuthenticator(
+		WithIntrospectURL(srv.URL),
+		WithClientCredentials("cid", "csecret"),
+		WithJWTIntrospection(jwtIntrospectionIssuer, keyfunc),
+	)
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken("valid-token")
+	assert.ErrorIs(t, err, engine.ErrInvalidToken)
+
+	auth, err = NewAuthenticator(
+		WithIntrospectURL(srv.URL),
+		WithClientCredentials("cid", "csecret"),
+		WithJWTIntrospection(jwtIntrospectionIssuer, keyfunc),
+		WithJWTIntrospectionAlgorithms("HS256"),
+	)
+	require.NoError(t, err)
+	claims, err := auth.AuthenticateToken("valid-token")
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user-123", sub)
+
+	_, err = NewAuthenticator(WithIntrospectURL(srv.URL), WithJWTIntrospectionAlgorithms("none"))
+	assert.NotNil(t, err)
+	_, err = NewAuthenticator(WithIntrospectURL(srv.URL), WithJWTIntrospectionAlgorithms())
+	assert.NotNil(t, err)
+}
+
+func TestJWTIntrospection_JWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "1",
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	srv := newJWTIntrospectionServer(t, key, nil)
+	defer srv.Close()
+
+	auth, err := NewAuthenticator(
+		WithIntrospectURL(srv.URL),
+		WithClientCredentials("cid", "csecret"),
+		WithJWTIntrospectionJWKS(jwtIntrospectionIssuer, jwks.URL),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	claims, err := auth.AuthenticateToken("valid-token")
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user-123", sub)
+}
+
+func TestJWTIntrospection_InvalidOptions(t *testing.T) {
+	_, err := NewAuthenticator(
+		WithIntrospectURL("http://localhost/introspect"),
+		WithJWTIntrospectionJWKS(jwtIntrospectionIssuer, "http://localhost/jwks"),
+	)
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(
+		WithIntrospectURL("http://localhost/introspect"),
+		WithClientCredentials("cid", "csecret"),
+		WithJWTIntrospectionJWKS(jwtIntrospectionIssuer, "localhost/jwks"),
+	)
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(
+		WithIntrospectURL("http://localhost/introspect"),
+		WithClientCredentials("cid", "csecret"),
+		WithJWTIntrospection("", nil),
+	)
+	assert.NotNil(t, err)
+}
+
 // ---------------------------------------------------------------------------
 // CreateIdentity
 // ---------------------------------------------------------------------------
@@ -1,8 +1,13 @@
 package oauth2
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
 )
 
 // Options holds configuration for the OAuth2 token-introspection authenticator.
@@ -10,8 +15,11 @@ type Options struct {
 	// introspectURL is the RFC 7662 token introspection endpoint.
 	introspectURL string
 
+	// revocationURL is the RFC 7009 token revocation endpoint.
+	revocationURL string
+
 	// clientID and clientSecret are used for authenticating the
-	// introspection request (HTTP Basic auth).
+	// introspection and revocation requests (HTTP Basic auth).
 	clientID     string
 	clientSecret string
 
@@ -22,33 +30,189 @@ type Options struct {
 	// extraClaimsKeys specifies additional claim keys to copy from the
 	// introspection response into AuthClaims (beyond the standard ones).
 	extraClaimsKeys []string
+
+	// cacheTTL, when positive, caches introspection results for at most
+	// that long; inactive results are cached for cacheNegativeTTL.
+	cacheTTL         time.Duration
+	cacheNegativeTTL time.Duration
+	cacheSize        int
+
+	// jwtIssuer, when set, asks for RFC 9701 JWT introspection responses
+	// issued by it, verified with jwtKeyfunc or the keys at jwtJWKSURL.
+	jwtIssuer  string
+	jwtKeyfunc jwtV5.Keyfunc
+	jwtJWKSURL string
+
+	// jwtAlgorithms are the signing algorithms accepted for JWT
+	// introspection responses.
+	jwtAlgorithms []string
 }
 
-type Option func(o *Options)
+type Option func(o *Options) error
 
 // WithIntrospectURL sets the RFC 7662 token introspection endpoint.
-func WithIntrospectURL(url string) Option {
-	return func(o *Options) { o.introspectURL = url }
+func WithIntrospectURL(rawURL string) Option {
+	return func(o *Options) error {
+		if err := validateEndpoint(rawURL); err != nil {
+			return fmt.Errorf("invalid introspection URL: %w", err)
+		}
+		o.introspectURL = rawURL
+		return nil
+	}
+}
+
+// WithRevocationURL sets the RFC 7009 token revocation endpoint.
+func WithRevocationURL(rawURL string) Option {
+	return func(o *Options) error {
+		if err := validateEndpoint(rawURL); err != nil {
+			return fmt.Errorf("invalid revocation URL: %w", err)
+		}
+		o.revocationURL = rawURL
+		return nil
+	}
 }
 
 // WithClientCredentials sets the client credentials used for
-// authenticating the introspection request.
+// authenticating the introspection and revocation requests.
 func WithClientCredentials(clientID, clientSecret string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		o.clientID = clientID
 		o.clientSecret = clientSecret
+		return nil
 	}
 }
 
-// WithHTTPClient sets a custom HTTP client for the introspection request.
+// WithHTTPClient sets a custom HTTP client for the introspection and
+// revocation requests.
 func WithHTTPClient(c *http.Client) Option {
-	return func(o *Options) { o.httpClient = c }
+	return func(o *Options) error {
+		o.httpClient = c
+		return nil
+	}
 }
 
 // WithExtraClaimsKeys specifies additional keys to copy from the
 // introspection response into AuthClaims.
 func WithExtraClaimsKeys(keys ...string) Option {
-	return func(o *Options) { o.extraClaimsKeys = append(o.extraClaimsKeys, keys...) }
+	return func(o *Options) error {
+		o.extraClaimsKeys = append(o.extraClaimsKeys, keys...)
+		return nil
+	}
+}
+
+// WithIntrospectionCache caches introspection results until the token
+// expires, for at most maxTTL, keeping up to maxEntries results (0 for
+// DefaultIntrospectionCacheSize). A cached token stays valid for up to
+// maxTTL after it was revoked elsewhere.
+func WithIntrospectionCache(maxTTL time.Duration, maxEntries int) Option {
+	return func(o *Options) error {
+		if maxTTL <= 0 {
+			return errors.New("introspection cache TTL must be positive")
+		}
+		if maxEntries < 0 {
+			return errors.New("introspection cache size must not be negative")
+		}
+		if maxEntries == 0 {
+			maxEntries = DefaultIntrospectionCacheSize
+		}
+		o.cacheTTL = maxTTL
+		o.cacheSize = maxEntries
+		return nil
+	}
+}
+
+// WithIntrospectionNegativeTTL sets how long inactive results are cached,
+// DefaultIntrospectionNegativeTTL by default; 0 disables it.
+func WithIntrospectionNegativeTTL(ttl time.Duration) Option {
+	return func(o *Options) error {
+		if ttl < 0 {
+			return errors.New("introspection negative TTL must not be negative")
+		}
+		o.cacheNegativeTTL = ttl
+		return nil
+	}
+}
+
+// WithJWTIntrospection asks for JWT introspection responses (RFC 9701)
+// issued by issuer and verifies them with keyfunc, e.g. the Keyfunc of a
+// key set created with keyfunc.NewJWKSetJSON. Plain JSON responses are
+// then rejected. It requires WithClientCredentials, as the responses are
+// addressed to the client.
+func WithJWTIntrospection(issuer string, keyfunc jwtV5.Keyfunc) Option {
+	return func(o *Options) error {
+		if issuer == "" {
+			return errors.New("JWT introspection issuer is required")
+		}
+		if keyfunc == nil {
+			return errors.New("JWT introspection keyfunc must not be nil")
+		}
+		o.jwtIssuer = issuer
+		o.jwtKeyfunc = keyfunc
+		o.jwtJWKSURL = ""
+		return nil
+	}
+}
+
+// WithJWTIntrospectionJWKS is like WithJWTIntrospection, verifying the
+// responses with the keys served at jwksURL, which are refreshed until
+// Close.
+func WithJWTIntrospectionJWKS(issuer, jwksURL string) Option {
+	return func(o *Options) error {
+		if issuer == "" {
+			return errors.New("JWT introspection issuer is required")
+		}
+		if err := validateEndpoint(jwksURL); err != nil {
+			return fmt.Errorf("invalid JWKS URL: %w", err)
+		}
+		o.jwtIssuer = issuer
+		o.jwtKeyfunc = nil
+		o.jwtJWKSURL = jwksURL
+		return nil
+	}
+}
+
+// WithJWTIntrospectionAlgorithms sets the signing algorithms accepted for
+// JWT introspection responses, DefaultJWTIntrospectionAlgorithms by default.
+// Symmetric algorithms such as HS256 are only accepted if listed here.
+func WithJWTIntrospectionAlgorithms(algs ...string) Option {
+	return func(o *Options) error {
+		if len(algs) == 0 {
+			return errors.New("at least one JWT introspection algorithm is required")
+		}
+		for _, alg := range algs {
+			if alg == "none" || jwtV5.GetSigningMethod(alg) == nil {
+				return fmt.Errorf("unsupported JWT introspection algorithm %q", alg)
+			}
+		}
+		o.jwtAlgorithms = algs
+		return nil
+	}
+}
+
+// validateEndpoint checks that rawURL is an absolute http(s) URL.
+func validateEndpoint(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http(s) URL", rawURL)
+	}
+	return nil
+}
+
+// validate checks that the configuration is complete and consistent.
+func (o *Options) validate() error {
+	if o.introspectURL == "" {
+		return errors.New("introspection URL is required")
+	}
+	if o.clientSecret != "" && o.clientID == "" {
+		return errors.New("client secret is set without a client ID")
+	}
+	if o.jwtIssuer != "" && o.clientID == "" {
+		return errors.New("JWT introspection requires a client ID")
+	}
+	return nil
 }
 
 func (o *Options) getHTTPClient() *http.Client {
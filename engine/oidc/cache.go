@@ -0,0 +1,87 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// claimsCache caches claims fetched for a token, keyed by the hash of the
+// token. Entries are fresh for ttl and kept as stale until the token
+// expires, to fall back on when the claims cannot be fetched again.
+type claimsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]claimsEntry
+
+	now func() time.Time
+}
+
+type claimsEntry struct {
+	claims     map[string]interface{}
+	expiresAt  time.Time
+	staleUntil time.Time
+}
+
+func newClaimsCache(ttl time.Duration) *claimsCache {
+	return &claimsCache{
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]claimsEntry),
+		now:     time.Now,
+	}
+}
+
+func (c *claimsCache) get(key [sha256.Size]byte) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.expiresAt) {
+		return nil, false
+	}
+	return e.claims, true
+}
+
+// getStale returns the claims cached for key, even if no longer fresh.
+func (c *claimsCache) getStale(key [sha256.Size]byte) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.staleUntil) {
+		return nil, false
+	}
+	return e.claims, true
+}
+
+// put caches claims for ttl, or until tokenExpiry if that is earlier, and
+// keeps them as stale until tokenExpiry. Claims of a token without expiry
+// are not kept beyond ttl.
+func (c *claimsCache) put(key [sha256.Size]byte, claims map[string]interface{}, tokenExpiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for k, e := range c.entries {
+		if !now.Before(e.staleUntil) {
+			delete(c.entries, k)
+		}
+	}
+
+	expiresAt := now.Add(c.ttl)
+	if !tokenExpiry.IsZero() && tokenExpiry.Before(expiresAt) {
+		expiresAt = tokenExpiry
+	}
+	staleUntil := expiresAt
+	if tokenExpiry.After(staleUntil) {
+		staleUntil = tokenExpiry
+	}
+	c.entries[key] = claimsEntry{claims: claims, expiresAt: expiresAt, staleUntil: staleUntil}
+}
+
+func (c *claimsCache) remove(key [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
@@ -0,0 +1,226 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+const (
+	claimFieldClaimNames   = "_claim_names"
+	claimFieldClaimSources = "_claim_sources"
+)
+
+// maxClaimSourceSize bounds the size of a distributed claims response.
+const maxClaimSourceSize = 1 << 20
+
+// DefaultClaimSourceCacheTTL is how long resolved claims are cached per
+// token, but never beyond the token's expiry.
+const DefaultClaimSourceCacheTTL = 5 * time.Minute
+
+// claimSources holds the "_claim_names" and "_claim_sources" claims.
+type claimSources struct {
+	ClaimNames   map[string]string      `json:"_claim_names"`
+	ClaimSources map[string]claimSource `json:"_claim_sources"`
+}
+
+// parseClaimSources extracts the claim sources from verified claims.
+func parseClaimSources(claims engine.AuthClaims) (*claimSources, error) {
+	raw, err := json.Marshal(map[string]interface{}{
+		claimFieldClaimNames:   claims[claimFieldClaimNames],
+		claimFieldClaimSources: claims[claimFieldClaimSources],
+	})
+	if err != nil {
+		return nil, engine.ErrInvalidClaims
+	}
+
+	var cs claimSources
+	if err = json.Unmarshal(raw, &cs); err != nil {
+		return nil, engine.ErrInvalidClaims
+	}
+	return &cs, nil
+}
+
+// ResolveClaims resolves the aggregated and distributed claims referenced by
+// "_claim_names" and "_claim_sources" and merges them into claims, which
+// must already be verified. Aggregated sources carry a signed JWT;
+// distributed sources are fetched from their endpoint with the source's own
+// access token. A distributed source without one is fetched without
+// credentials if its host is allowed with WithClaimSourceHosts, and refused
+// otherwise. Every source JWT must be signed by the provider, issued by it,
+// and about the subject of claims. Claims of the token are replaced by the
+// resolved values, and the two reference claims are removed once resolved.
+//
+// Azure AD reports group overage this way.
+// See: https://openid.net/specs/openid-connect-core-1_0.html#AggregatedDistributedClaims
+func (a *Authenticator) ResolveClaims(ctx context.Context, claims engine.AuthClaims) error {
+	if _, ok := claims[claimFieldClaimNames]; !ok {
+		return nil
+	}
+
+	resolved, err := a.fetchClaims(ctx, claims)
+	if err != nil {
+		return err
+	}
+	mergeResolvedClaims(claims, resolved)
+	return nil
+}
+
+// resolveClaims is ResolveClaims for the verified token, caching the
+// resolved claims per token until it expires.
+func (a *Authenticator) resolveClaims(ctx context.Context, token string, claims engine.AuthClaims) error {
+	if _, ok := claims[claimFieldClaimNames]; !ok {
+		return nil
+	}
+
+	key := sha256.Sum256([]byte(token))
+
+	resolved, ok := a.claimSourceCache.get(key)
+	if !ok {
+		var err error
+		if resolved, err = a.fetchClaims(ctx, claims); err != nil {
+			return err
+		}
+
+		expiresAt := time.Time{}
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			expiresAt = exp.Time
+		}
+		a.claimSourceCache.put(key, resolved, expiresAt)
+	}
+
+	mergeResolvedClaims(claims, resolved)
+	return nil
+}
+
+// fetchClaims returns the values of the claims named in "_claim_names".
+func (a *Authenticator) fetchClaims(ctx context.Context, claims engine.AuthClaims) (map[string]interface{}, error) {
+	if err := a.checkReady(); err != nil {
+		return nil, err
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, engine.ErrInvalidSubject
+	}
+
+	cs, err := parseClaimSources(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each source is fetched and verified once, however many claims it holds.
+	sources := make(map[string]jwtV5.MapClaims)
+	resolved := make(map[string]interface{}, len(cs.ClaimNames))
+	for name, sourceName := range cs.ClaimNames {
+		sourceClaims, ok := sources[sourceName]
+		if !ok {
+			source, ok := cs.ClaimSources[sourceName]
+			if !ok {
+				return nil, engine.ErrInvalidClaims
+			}
+			if sourceClaims, err = a.resolveClaimSource(ctx, source, sub); err != nil {
+				return nil, err
+			}
+			sources[sourceName] = sourceClaims
+		}
+
+		if v, ok := sourceClaims[name]; ok {
+			resolved[name] = v
+		}
+	}
+	return resolved, nil
+}
+
+// mergeResolvedClaims replaces the claims of the token by their resolved
+// values and removes the reference claims.
+func mergeResolvedClaims(claims engine.AuthClaims, resolved map[string]interface{}) {
+	for k, v := range resolved {
+		claims[k] = v
+	}
+
+	delete(claims, claimFieldClaimNames)
+	delete(claims, claimFieldClaimSources)
+}
+
+// resolveClaimSource returns the verified claims of an aggregated or
+// distributed claim source about subject sub.
+func (a *Authenticator) resolveClaimSource(ctx context.Context, source claimSource, sub string) (jwtV5.MapClaims, error) {
+	token := source.JWT
+	if token == "" {
+		if source.Endpoint == "" {
+			return nil, engine.ErrInvalidClaims
+		}
+
+		// The endpoint is named by the token; only the source's own access
+		// token is ever sent to it.
+		if source.AccessToken == "" && !a.options.isClaimSourceHostAllowed(source.Endpoint) {
+			return nil, engine.ErrInvalidClaims
+		}
+
+		var err error
+		if token, err = a.fetchClaimSource(ctx, source.Endpoint, source.AccessToken); err != nil {
+			return nil, err
+		}
+	}
+
+	alg, err := tokenAlgorithm(token)
+	if err != nil {
+		return nil, err
+	}
+	if !a.isAllowedAlgorithm(alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+
+	claims := jwtV5.MapClaims{}
+	if _, err = jwtV5.ParseWithClaims(token, claims, a.JWKs.Keyfunc, jwtV5.WithValidMethods(a.algorithms)); err != nil {
+		return nil, mapParseError(err)
+	}
+
+	// The claims must be about the same user, from the same provider.
+	if iss, _ := claims.GetIssuer(); iss != a.options.IssuerURL {
+		return nil, engine.ErrInvalidIssuer
+	}
+	if sourceSub, _ := claims.GetSubject(); sourceSub != sub {
+		return nil, engine.ErrInvalidSubject
+	}
+	return claims, nil
+}
+
+// fetchClaimSource fetches the claims JWT of a distributed claim source.
+func (a *Authenticator) fetchClaimSource(ctx context.Context, endpoint, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", engine.ErrInvalidClaims
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", engine.BearerWord+" "+accessToken)
+	}
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", engine.ErrUnauthenticated
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return "", engine.ErrUnauthenticated
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxClaimSourceSize))
+	if err != nil {
+		return "", engine.ErrUnauthenticated
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
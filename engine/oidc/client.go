@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const (
+	// DefaultRetryMax is how often a failed provider request is retried.
+	DefaultRetryMax = 4
+	// DefaultRetryWaitMin is the first wait before a retry.
+	DefaultRetryWaitMin = time.Second
+	// DefaultRetryWaitMax caps the wait before a retry.
+	DefaultRetryWaitMax = 30 * time.Second
+)
+
+// newHTTPClient builds the client for discovery, JWKS, UserInfo and token
+// requests: the configured client, or a pooled one honouring the proxy
+// environment, trusting the configured root CAs and retrying failed requests
+// with the configured policy.
+func (o *Options) newHTTPClient() (*http.Client, error) {
+	client := o.httpClient
+	if client == nil {
+		client = cleanhttp.DefaultPooledClient()
+	}
+
+	if o.rootCAs != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		transport, ok := base.(*http.Transport)
+		if !ok {
+			return nil, errors.New("root CAs require the http client to use an *http.Transport")
+		}
+
+		transport = transport.Clone()
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLSClientConfig.RootCAs = o.rootCAs
+
+		c := *client
+		c.Transport = transport
+		client = &c
+	}
+
+	retryClient := retryablehttp.NewClient()
+	retryClient.HTTPClient = client
+	retryClient.RetryMax = o.retryMax
+	retryClient.RetryWaitMin = o.retryWaitMin
+	retryClient.RetryWaitMax = o.retryWaitMax
+	retryClient.Logger = retryLogger{log: o.log}
+
+	return retryClient.StandardClient(), nil
+}
+
+// retryLogger logs retryablehttp messages to the configured logger.
+type retryLogger struct {
+	log *log.Helper
+}
+
+var _ retryablehttp.LeveledLogger = retryLogger{}
+
+func (l retryLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log.Errorw(append([]interface{}{"msg", msg}, keysAndValues...)...)
+}
+
+func (l retryLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log.Infow(append([]interface{}{"msg", msg}, keysAndValues...)...)
+}
+
+func (l retryLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log.Debugw(append([]interface{}{"msg", msg}, keysAndValues...)...)
+}
+
+func (l retryLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log.Warnw(append([]interface{}{"msg", msg}, keysAndValues...)...)
+}
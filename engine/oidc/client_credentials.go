@@ -0,0 +1,256 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTokenRefreshBefore is how long before its expiry a cached
+	// access token is replaced.
+	DefaultTokenRefreshBefore = time.Minute
+
+	// DefaultTokenLifetime is how long an access token is cached if the
+	// provider did not say when it expires.
+	DefaultTokenLifetime = 5 * time.Minute
+)
+
+// Token is an access token issued to the client itself.
+type Token struct {
+	AccessToken string
+	TokenType   string
+	// Expiry is zero if the provider did not say when the token expires.
+	Expiry time.Time
+}
+
+// ClientCredentialsTokenSource gets access tokens for calls made by the
+// service itself with the client credentials grant, and caches them until
+// shortly before they expire. It is safe for concurrent use; concurrent
+// callers share one token request, and while a cached token that is about to
+// expire is replaced, callers keep getting it without waiting.
+//
+// See: https://datatracker.ietf.org/doc/html/rfc6749#section-4.4
+type ClientCredentialsTokenSource struct {
+	provider     *Authenticator
+	clientID     string
+	clientSecret string
+
+	scopes          []string
+	audiences       []string
+	resources       []string
+	refreshBefore   time.Duration
+	defaultLifetime time.Duration
+	now             func() time.Time
+
+	mu        sync.Mutex
+	token     *Token
+	expiresAt time.Time
+	refreshAt time.Time
+	call      *tokenCall
+}
+
+// tokenCall is a token request in flight.
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// ClientCredentialsOption configures a ClientCredentialsTokenSource.
+type ClientCredentialsOption func(s *ClientCredentialsTokenSource) error
+
+// WithTokenScopes sets the scopes to request.
+func WithTokenScopes(scopes ...string) ClientCredentialsOption {
+	return func(s *ClientCredentialsTokenSource) error {
+		s.scopes = scopes
+		return nil
+	}
+}
+
+// WithTokenAudience sets the "audience" parameter, which providers such as
+// Auth0 require to issue tokens for an API.
+func WithTokenAudience(audiences ...string) ClientCredentialsOption {
+	return func(s *ClientCredentialsTokenSource) error {
+		s.audiences = audiences
+		return nil
+	}
+}
+
+// WithTokenResource sets the resource indicators of the services the token
+// is for.
+//
+// See: https://datatracker.ietf.org/doc/html/rfc8707
+func WithTokenResource(resources ...string) ClientCredentialsOption {
+	return func(s *ClientCredentialsTokenSource) error {
+		for _, resource := range resources {
+			u, err := url.Parse(resource)
+			if err != nil || !u.IsAbs() || u.Fragment != "" {
+				return errors.New("resource must be an absolute URI without fragment")
+			}
+		}
+		s.resources = resources
+		return nil
+	}
+}
+
+// WithTokenRefreshBefore sets how long before its expiry a cached token is
+// replaced. Tokens are replaced after half their lifetime at the latest.
+func WithTokenRefreshBefore(d time.Duration) ClientCredentialsOption {
+	return func(s *ClientCredentialsTokenSource) error {
+		if d < 0 {
+			return errors.New("token refresh time must not be negative")
+		}
+		s.refreshBefore = d
+		return nil
+	}
+}
+
+// WithTokenDefaultLifetime sets how long a token is cached if the provider
+// did not say when it expires.
+func WithTokenDefaultLifetime(d time.Duration) ClientCredentialsOption {
+	return func(s *ClientCredentialsTokenSource) error {
+		if d <= 0 {
+			return errors.New("default token lifetime must be positive")
+		}
+		s.defaultLifetime = d
+		return nil
+	}
+}
+
+// NewClientCredentialsTokenSource creates a token source for the confidential
+// client clientID, requesting tokens from the token endpoint of the provider.
+func (a *Authenticator) NewClientCredentialsTokenSource(clientID, clientSecret string, opts ...ClientCredentialsOption) (*ClientCredentialsTokenSource, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, errors.New("client ID and secret are required")
+	}
+
+	s := &ClientCredentialsTokenSource{
+		provider:        a,
+		clientID:        clientID,
+		clientSecret:    clientSecret,
+		refreshBefore:   DefaultTokenRefreshBefore,
+		defaultLifetime: DefaultTokenLifetime,
+		now:             time.Now,
+	}
+
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Token returns the cached access token, or requests a new one if there is
+// none or it is about to expire. A token that is about to expire is
+// returned while the new one is requested in the background. The request is
+// not canceled with ctx while other callers wait for it; a caller whose ctx
+// ends stops waiting.
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+
+	now := s.now()
+	token := s.token
+	if token != nil && now.Before(s.refreshAt) {
+		s.mu.Unlock()
+		return token, nil
+	}
+
+	call := s.call
+	if call == nil {
+		call = &tokenCall{done: make(chan struct{})}
+		s.call = call
+		go s.fetch(context.WithoutCancel(ctx), call)
+	}
+
+	if token != nil && now.Before(s.expiresAt) {
+		s.mu.Unlock()
+		return token, nil
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch requests a token for call and caches it.
+func (s *ClientCredentialsTokenSource) fetch(ctx context.Context, call *tokenCall) {
+	token, expiresIn, err := s.requestToken(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		now := s.now()
+
+		// A token of unknown lifetime is cached for the default lifetime.
+		lifetime := s.defaultLifetime
+		if expiresIn > 0 {
+			lifetime = expiresIn
+			token.Expiry = now.Add(lifetime)
+		}
+
+		early := s.refreshBefore
+		if early > lifetime/2 {
+			early = lifetime / 2
+		}
+		s.token = token
+		s.expiresAt = now.Add(lifetime)
+		s.refreshAt = s.expiresAt.Add(-early)
+	}
+
+	call.token, call.err = token, err
+	s.call = nil
+	close(call.done)
+}
+
+// requestToken requests a token from the token endpoint and returns it with
+// its lifetime, or 0 if the provider did not say.
+func (s *ClientCredentialsTokenSource) requestToken(ctx context.Context) (*Token, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	for _, audience := range s.audiences {
+		form.Add("audience", audience)
+	}
+	for _, resource := range s.resources {
+		form.Add("resource", resource)
+	}
+
+	res, err := s.provider.requestToken(ctx, s.clientID, s.clientSecret, form)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	token := &Token{AccessToken: res.AccessToken, TokenType: res.TokenType}
+	return token, time.Duration(res.ExpiresIn) * time.Second, nil
+}
+
+// Invalidate drops the cached token, e.g. after it was rejected, so that the
+// next call to Token requests a new one.
+func (s *ClientCredentialsTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = nil
+}
+
+// forget drops the cached token if it is accessToken, e.g. once revoked.
+func (s *ClientCredentialsTokenSource) forget(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken == accessToken {
+		s.token = nil
+	}
+}
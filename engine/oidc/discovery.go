@@ -0,0 +1,276 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	keyfuncV3 "github.com/MicahParks/keyfunc/v3"
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+const (
+	// DefaultDiscoveryRetryMin is the first delay between discovery attempts
+	// in the background.
+	DefaultDiscoveryRetryMin = time.Second
+	// DefaultDiscoveryRetryMax caps the delay between discovery attempts.
+	DefaultDiscoveryRetryMax = time.Minute
+)
+
+// Ready returns a channel that is closed once the provider is available,
+// either discovered or warm-started from the discovery cache.
+func (a *Authenticator) Ready() <-chan struct{} {
+	return a.ready
+}
+
+// checkReady returns engine.ErrProviderUnavailable until the provider is
+// available. Provider fields must only be read after it returned nil.
+func (a *Authenticator) checkReady() error {
+	select {
+	case <-a.ready:
+		return nil
+	default:
+		return engine.ErrProviderUnavailable
+	}
+}
+
+// setProvider publishes the provider configuration and keys. The first call
+// makes the authenticator ready; later calls only replace the keys, e.g.
+// when a warm-started authenticator reaches the provider.
+func (a *Authenticator) setProvider(config *ProviderConfig, algs []string, jwks keyfuncV3.Keyfunc) {
+	a.keys.set(jwks)
+	a.readyOnce.Do(func() {
+		a.providerConfig = config
+		a.JwksURI = config.JWKSURL
+		a.algorithms = algs
+		a.JWKs = a.keys
+		close(a.ready)
+	})
+}
+
+// discover fetches the discovery document and the keys of the provider.
+func (a *Authenticator) discover() error {
+	oidcConfig, err := a.GetConfiguration()
+	if err != nil {
+		return fmt.Errorf("error fetching OIDC configuration: %w", err)
+	}
+
+	algs, err := resolveAlgorithms(a.options.algorithms, oidcConfig.Algorithms)
+	if err != nil {
+		return err
+	}
+
+	remote := a.newRemoteKeySet(oidcConfig.JWKSURL)
+
+	jwks, err := remote.keyfunc()
+	if err != nil {
+		remote.Close()
+		return fmt.Errorf("error creating OIDC keyfunc: %w", err)
+	}
+
+	keys, err := remote.JSONPublic(a.ctx)
+	if err != nil {
+		remote.Close()
+		return fmt.Errorf("error reading OIDC keys: %w", err)
+	}
+
+	// Stay unavailable, or keep the cached keys of a warm start, until the
+	// provider serves keys; without any, every token would be rejected as
+	// invalid. Discovery is retried meanwhile.
+	if !hasKeys(keys) {
+		remote.Close()
+		return errors.New("provider JWKS has no keys")
+	}
+
+	if a.options.discoveryCachePath != "" {
+		// The cache only speeds up the next start; failing to write it does
+		// not fail discovery.
+		if err = saveDiscoveryCache(a.options.discoveryCachePath, oidcConfig, keys); err != nil {
+			a.options.log.Warnf("saving OIDC discovery cache failed: %s", err.Error())
+		}
+	}
+
+	a.remoteKeys.Store(remote)
+	a.setProvider(oidcConfig, algs, jwks)
+
+	return nil
+}
+
+// configure sets up the provider from the static configuration instead of
+// discovery. Static keys need no request at all; otherwise the keys are
+// fetched from the configured jwks_uri.
+func (a *Authenticator) configure() error {
+	config := a.options.providerConfig
+
+	algs, err := resolveAlgorithms(a.options.algorithms, config.Algorithms)
+	if err != nil {
+		return err
+	}
+
+	if a.options.jwks != nil {
+		jwks, err := keyfuncV3.NewJWKSetJSON(a.options.jwks)
+		if err != nil {
+			return fmt.Errorf("error parsing OIDC keys: %w", err)
+		}
+		a.setProvider(config, algs, jwks)
+		return nil
+	}
+
+	remote := a.newRemoteKeySet(config.JWKSURL)
+	jwks, err := remote.keyfunc()
+	if err != nil {
+		remote.Close()
+		return fmt.Errorf("error creating OIDC keyfunc: %w", err)
+	}
+
+	a.remoteKeys.Store(remote)
+	a.setProvider(config, algs, jwks)
+
+	return nil
+}
+
+// discoverInBackground retries discovery with exponential backoff until it
+// succeeds or the authenticator is closed.
+func (a *Authenticator) discoverInBackground() {
+	defer a.background.Done()
+
+	delay := a.options.discoveryRetryMin
+	for {
+		err := a.discover()
+		if err == nil {
+			return
+		}
+		a.options.log.Warnf("OIDC discovery of %s failed, retrying in %s: %s", a.options.IssuerURL, delay, err.Error())
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > a.options.discoveryRetryMax {
+			delay = a.options.discoveryRetryMax
+		}
+	}
+}
+
+// discoveryCache is the discovery document and key set saved to disk.
+type discoveryCache struct {
+	Discovery *ProviderConfig `json:"discovery"`
+	JWKS      json.RawMessage `json:"jwks"`
+}
+
+// loadDiscoveryCache warm-starts the authenticator from the discovery cache.
+// A cache of another issuer is ignored.
+func (a *Authenticator) loadDiscoveryCache() error {
+	raw, err := os.ReadFile(a.options.discoveryCachePath)
+	if err != nil {
+		return err
+	}
+
+	var cache discoveryCache
+	if err = json.Unmarshal(raw, &cache); err != nil {
+		return err
+	}
+	if cache.Discovery == nil || cache.Discovery.JWKSURL == "" ||
+		strings.TrimSuffix(cache.Discovery.Issuer, "/") != strings.TrimSuffix(a.options.IssuerURL, "/") {
+		return errors.New("discovery cache does not match the issuer")
+	}
+	if !hasKeys(cache.JWKS) {
+		return errors.New("discovery cache has no keys")
+	}
+
+	algs, err := resolveAlgorithms(a.options.algorithms, cache.Discovery.Algorithms)
+	if err != nil {
+		return err
+	}
+
+	jwks, err := keyfuncV3.NewJWKSetJSON(cache.JWKS)
+	if err != nil {
+		return err
+	}
+
+	a.setProvider(cache.Discovery, algs, jwks)
+
+	return nil
+}
+
+// saveDiscoveryCache atomically replaces the discovery cache at path.
+func saveDiscoveryCache(path string, config *ProviderConfig, keys json.RawMessage) error {
+	raw, err := json.Marshal(discoveryCache{Discovery: config, JWKS: keys})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err = f.Write(raw); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// hasKeys reports whether a JWK Set holds any key.
+func hasKeys(raw json.RawMessage) bool {
+	return jwksKeyCount(raw) > 0
+}
+
+// jwksKeyCount returns the number of keys in a JWK Set, 0 if it is invalid.
+func jwksKeyCount(raw json.RawMessage) int {
+	var set jwkset.JWKSMarshal
+	if json.Unmarshal(raw, &set) != nil {
+		return 0
+	}
+	return len(set.Keys)
+}
+
+// keySet is the key set of the provider. Its keys are replaced when a
+// warm-started authenticator reaches the provider.
+type keySet struct {
+	current atomic.Pointer[keyfuncV3.Keyfunc]
+}
+
+var _ keyfuncV3.Keyfunc = (*keySet)(nil)
+
+func (k *keySet) set(jwks keyfuncV3.Keyfunc) {
+	k.current.Store(&jwks)
+}
+
+func (k *keySet) get() keyfuncV3.Keyfunc {
+	return *k.current.Load()
+}
+
+func (k *keySet) Keyfunc(token *jwtV5.Token) (any, error) {
+	return k.get().Keyfunc(token)
+}
+
+func (k *keySet) KeyfuncCtx(ctx context.Context) jwtV5.Keyfunc {
+	return k.get().KeyfuncCtx(ctx)
+}
+
+func (k *keySet) Storage() jwkset.Storage {
+	return k.get().Storage()
+}
+
+func (k *keySet) VerificationKeySet(ctx context.Context) (jwtV5.VerificationKeySet, error) {
+	return k.get().VerificationKeySet(ctx)
+}
@@ -48,8 +48,8 @@ type IDToken struct {
 
 	// Initial nonce provided during the authentication redirect.
 	//
-	// This package does NOT provide verification on the value of this field,
-	// and it's the user's responsibility to ensure it contains a valid value.
+	// IDTokenVerifier.VerifyWithNonce checks this value against the nonce
+	// sent in the authentication request.
 	Nonce string
 
 	// at_hash claim, if set in the ID token. Callers can verify an access token
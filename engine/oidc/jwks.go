@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	keyfuncV3 "github.com/MicahParks/keyfunc/v3"
+	"github.com/go-kratos/kratos/v2/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultJWKSRefreshInterval is how often the provider keys are refreshed.
+	DefaultJWKSRefreshInterval = time.Hour
+	// DefaultJWKSUnknownKIDRefreshInterval is the minimum time between
+	// refreshes triggered by tokens with an unknown "kid".
+	DefaultJWKSUnknownKIDRefreshInterval = 5 * time.Minute
+	// DefaultJWKSRequestTimeout bounds a single key set request.
+	DefaultJWKSRequestTimeout = 10 * time.Second
+)
+
+// maxJWKSSize bounds the size of a key set response.
+const maxJWKSSize = 1 << 20
+
+// JWKSStatus reports the state of the provider key set, e.g. for health
+// checks.
+type JWKSStatus struct {
+	// URL is the jwks_uri of the provider.
+	URL string
+	// LastRefresh is the time of the last successful refresh.
+	LastRefresh time.Time
+	// LastError is the error of the last refresh, nil if it succeeded.
+	LastError error
+	// Keys is the number of keys in the set.
+	Keys int
+}
+
+// remoteKeySet is the key set served at the jwks_uri of the provider. It is
+// refreshed periodically and, rate limited, when a token names an unknown
+// key. A rate-limited lookup fails right away instead of waiting.
+type remoteKeySet struct {
+	jwkset.Storage
+
+	url        string
+	client     *http.Client
+	log        *log.Helper
+	timeout    time.Duration
+	unknownKID *rate.Limiter
+
+	// refreshMu serializes refreshes.
+	refreshMu sync.Mutex
+
+	mu     sync.Mutex
+	status JWKSStatus
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newRemoteKeySet fetches the key set once and refreshes it in the
+// background until Close. A failed first fetch is reported in the status;
+// the key set keeps trying.
+func (a *Authenticator) newRemoteKeySet(jwksURI string) *remoteKeySet {
+	o := a.options
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	k := &remoteKeySet{
+		Storage: jwkset.NewMemoryStorage(),
+		url:     jwksURI,
+		client:  a.httpClient,
+		log:     o.log,
+		timeout: o.jwksRequestTimeout,
+		status:  JWKSStatus{URL: jwksURI},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	if o.jwksHTTPClient != nil {
+		k.client = o.jwksHTTPClient
+	}
+	if o.jwksUnknownKIDRefreshInterval > 0 {
+		k.unknownKID = rate.NewLimiter(rate.Every(o.jwksUnknownKIDRefreshInterval), 1)
+	}
+
+	_ = k.refresh(ctx)
+
+	go func() {
+		defer close(k.done)
+
+		ticker := time.NewTicker(o.jwksRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = k.refresh(ctx)
+			}
+		}
+	}()
+
+	return k
+}
+
+// keyfunc returns a Keyfunc reading keys from the set.
+func (k *remoteKeySet) keyfunc() (keyfuncV3.Keyfunc, error) {
+	return keyfuncV3.New(keyfuncV3.Options{Storage: k})
+}
+
+// Close stops the background refresh and waits for it to end.
+func (k *remoteKeySet) Close() {
+	k.cancel()
+	<-k.done
+}
+
+// Status returns the state of the key set.
+func (k *remoteKeySet) Status() JWKSStatus {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.status
+}
+
+// KeyRead reads the key with the ID, refreshing the set first if the key is
+// unknown and the rate limit allows.
+func (k *remoteKeySet) KeyRead(ctx context.Context, keyID string) (jwkset.JWK, error) {
+	jwk, err := k.Storage.KeyRead(ctx, keyID)
+	if !errors.Is(err, jwkset.ErrKeyNotFound) || k.unknownKID == nil || !k.unknownKID.Allow() {
+		return jwk, err
+	}
+
+	if err = k.refresh(ctx); err != nil {
+		return jwkset.JWK{}, err
+	}
+	return k.Storage.KeyRead(ctx, keyID)
+}
+
+// refresh replaces the keys with the ones served by the provider. Keys that
+// this package does not support are skipped.
+func (k *remoteKeySet) refresh(ctx context.Context) error {
+	k.refreshMu.Lock()
+	defer k.refreshMu.Unlock()
+
+	keys, err := k.fetch(ctx)
+	if err == nil {
+		err = k.Storage.KeyReplaceAll(ctx, keys)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.status.LastError = err
+	if err != nil {
+		k.log.Errorf("refreshing OIDC keys from %s failed: %s", k.url, err.Error())
+	} else {
+		k.status.LastRefresh = time.Now()
+		k.status.Keys = len(keys)
+	}
+	return err
+}
+
+func (k *remoteKeySet) fetch(ctx context.Context) ([]jwkset.JWK, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error forming jwks request: %w", err)
+	}
+
+	res, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting jwks: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code getting jwks: %v", res.StatusCode)
+	}
+
+	var set jwkset.JWKSMarshal
+	if err = json.NewDecoder(io.LimitReader(res.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed parsing jwks: %w", err)
+	}
+
+	keys := make([]jwkset.JWK, 0, len(set.Keys))
+	for _, marshal := range set.Keys {
+		jwk, err := jwkset.NewJWKFromMarshal(marshal, jwkset.JWKMarshalOptions{}, jwkset.JWKValidateOptions{})
+		switch {
+		case errors.Is(err, jwkset.ErrUnsupportedKey):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed parsing jwk %q: %w", marshal.KID, err)
+		}
+		keys = append(keys, jwk)
+	}
+	return keys, nil
+}
@@ -0,0 +1,340 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	kratosHttp "github.com/go-kratos/kratos/v2/transport/http"
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+const (
+	// BackChannelLogoutEvent is the member of the "events" claim that marks
+	// a logout token.
+	BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+	// LogoutTokenType is the "typ" header of explicitly typed logout tokens.
+	LogoutTokenType = "logout+jwt"
+
+	// DefaultLogoutTokenMaxAge is how old a logout token may be, counted
+	// from its "iat".
+	DefaultLogoutTokenMaxAge = 2 * time.Minute
+)
+
+// maxLogoutRequestSize bounds the size of a back-channel logout request.
+const maxLogoutRequestSize = 64 << 10
+
+// LogoutToken is a verified back-channel logout token. It names the session
+// to end by SessionID, or all sessions of Subject if SessionID is empty.
+type LogoutToken struct {
+	Issuer    string
+	Subject   string
+	SessionID string
+	Audience  []string
+	IssuedAt  time.Time
+	JwtID     string
+
+	claims []byte
+}
+
+// Claims unmarshal the raw JSON payload of the logout token into v.
+func (t *LogoutToken) Claims(v interface{}) error {
+	if t.claims == nil {
+		return errors.New("oidc: claims not set")
+	}
+	return json.Unmarshal(t.claims, v)
+}
+
+// LogoutHook ends the sessions named by a verified logout token, e.g. by
+// deleting them from a session.SessionStore or revoking their tokens. It
+// must be idempotent: the provider may deliver a logout more than once.
+type LogoutHook func(ctx context.Context, token *LogoutToken) error
+
+// ReplayCache records the IDs of accepted logout tokens, so that every
+// token is accepted once. Implementations must be safe for concurrent use.
+type ReplayCache interface {
+	// Add records jti until expiresAt and reports whether it was new.
+	Add(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+	// Remove forgets jti, so that a logout that failed can be retried.
+	Remove(ctx context.Context, jti string) error
+}
+
+// BackChannelLogout receives logout tokens sent by the provider when a
+// user signs out there.
+//
+// See: https://openid.net/specs/openid-connect-backchannel-1_0.html
+type BackChannelLogout struct {
+	provider *Authenticator
+	clientID string
+	hook     LogoutHook
+
+	replayCache ReplayCache
+	maxAge      time.Duration
+	now         func() time.Time
+}
+
+// BackChannelLogoutOption configures a BackChannelLogout.
+type BackChannelLogoutOption func(l *BackChannelLogout) error
+
+// WithReplayCache sets the cache of accepted logout token IDs. Defaults to
+// an in-memory cache, which only suits a single instance.
+func WithReplayCache(cache ReplayCache) BackChannelLogoutOption {
+	return func(l *BackChannelLogout) error {
+		if cache == nil {
+			return errors.New("replay cache must not be nil")
+		}
+		l.replayCache = cache
+		return nil
+	}
+}
+
+// WithLogoutTokenMaxAge sets how old a logout token may be.
+func WithLogoutTokenMaxAge(maxAge time.Duration) BackChannelLogoutOption {
+	return func(l *BackChannelLogout) error {
+		if maxAge <= 0 {
+			return errors.New("logout token max age must be positive")
+		}
+		l.maxAge = maxAge
+		return nil
+	}
+}
+
+// NewBackChannelLogout creates a receiver for logout tokens issued to
+// clientID, calling hook for every verified one.
+func (a *Authenticator) NewBackChannelLogout(clientID string, hook LogoutHook, opts ...BackChannelLogoutOption) (*BackChannelLogout, error) {
+	if clientID == "" {
+		return nil, errors.New("client ID is required")
+	}
+	if hook == nil {
+		return nil, errors.New("logout hook must not be nil")
+	}
+
+	l := &BackChannelLogout{
+		provider: a,
+		clientID: clientID,
+		hook:     hook,
+		maxAge:   DefaultLogoutTokenMaxAge,
+		now:      time.Now,
+	}
+
+	for _, o := range opts {
+		if err := o(l); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.replayCache == nil {
+		l.replayCache = NewMemoryReplayCache()
+	}
+
+	return l, nil
+}
+
+// Verify checks the signature, issuer, audience, age and "events" claim of
+// the raw logout token, that it names a session or subject and that it
+// carries no nonce, so that an ID token cannot be passed off as one.
+// Replays are not checked; see Logout.
+func (l *BackChannelLogout) Verify(_ context.Context, rawToken string) (*LogoutToken, error) {
+	a := l.provider
+	if err := a.checkReady(); err != nil {
+		return nil, err
+	}
+
+	alg, err := tokenAlgorithm(rawToken)
+	if err != nil {
+		return nil, err
+	}
+	if !a.isAllowedAlgorithm(alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+
+	claims := jwtV5.MapClaims{}
+	token, err := jwtV5.ParseWithClaims(rawToken, claims, a.JWKs.Keyfunc,
+		jwtV5.WithValidMethods(a.algorithms),
+		jwtV5.WithIssuer(a.options.IssuerURL),
+		jwtV5.WithAudience(l.clientID),
+		jwtV5.WithIssuedAt(),
+		jwtV5.WithTimeFunc(l.now),
+	)
+	if err != nil {
+		return nil, mapParseError(err)
+	}
+
+	if typ, ok := token.Header["typ"]; ok && !isLogoutTokenType(typ) {
+		return nil, engine.ErrInvalidToken
+	}
+
+	authClaims := engine.AuthClaims(claims)
+
+	iat, err := authClaims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return nil, engine.ErrInvalidIssuedAt
+	}
+	if l.now().Sub(iat.Time) > l.maxAge {
+		return nil, engine.ErrTokenExpired
+	}
+
+	events, ok := claims["events"].(map[string]interface{})
+	if !ok {
+		return nil, engine.ErrInvalidClaims
+	}
+	if _, ok = events[BackChannelLogoutEvent].(map[string]interface{}); !ok {
+		return nil, engine.ErrInvalidClaims
+	}
+
+	if _, ok = claims["nonce"]; ok {
+		return nil, engine.ErrInvalidClaims
+	}
+
+	logoutToken := &LogoutToken{IssuedAt: iat.Time}
+	if logoutToken.Issuer, err = authClaims.GetIssuer(); err != nil {
+		return nil, engine.ErrInvalidIssuer
+	}
+	if logoutToken.Audience, err = authClaims.GetAudience(); err != nil {
+		return nil, engine.ErrInvalidAudience
+	}
+	if logoutToken.Subject, err = authClaims.GetSubject(); err != nil {
+		return nil, engine.ErrInvalidSubject
+	}
+	if logoutToken.SessionID, err = authClaims.GetString("sid"); err != nil {
+		return nil, engine.ErrInvalidClaims
+	}
+	if logoutToken.Subject == "" && logoutToken.SessionID == "" {
+		return nil, engine.ErrInvalidClaims
+	}
+	if logoutToken.JwtID, err = authClaims.GetJwtID(); err != nil || logoutToken.JwtID == "" {
+		return nil, engine.ErrMissingJwtId
+	}
+	if logoutToken.claims, err = tokenPayload(rawToken); err != nil {
+		return nil, err
+	}
+
+	return logoutToken, nil
+}
+
+// Logout verifies the raw logout token, rejects replays by its "jti" and
+// calls the hook. If the hook fails, the token may be sent again.
+func (l *BackChannelLogout) Logout(ctx context.Context, rawToken string) (*LogoutToken, error) {
+	token, err := l.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := l.replayCache.Add(ctx, token.JwtID, token.IssuedAt.Add(l.maxAge))
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, engine.ErrInvalidJwtID
+	}
+
+	if err = l.hook(ctx, token); err != nil {
+		_ = l.replayCache.Remove(ctx, token.JwtID)
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// RegisterHandler registers Handler on the server under path, the
+// backchannel_logout_uri registered with the provider.
+func (l *BackChannelLogout) RegisterHandler(srv *kratosHttp.Server, path string) {
+	srv.Route("/").POST(path, l.Handler())
+}
+
+// Handler receives the "logout_token" form parameter posted by the
+// provider, and answers 200 once the sessions are ended, or 400 with an
+// OAuth error response.
+func (l *BackChannelLogout) Handler() kratosHttp.HandlerFunc {
+	return func(ctx kratosHttp.Context) error {
+		w := ctx.Response()
+		w.Header().Set("Cache-Control", "no-store")
+
+		r := ctx.Request()
+		r.Body = http.MaxBytesReader(w, r.Body, maxLogoutRequestSize)
+
+		rawToken := r.PostFormValue("logout_token")
+		if rawToken == "" {
+			return writeLogoutError(w, "invalid_request", "missing logout_token")
+		}
+
+		if _, err := l.Logout(ctx, rawToken); err != nil {
+			return writeLogoutError(w, "invalid_request", err.Error())
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+}
+
+func writeLogoutError(w http.ResponseWriter, code, description string) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	return json.NewEncoder(w).Encode(tokenErrorResponse{Error: code, ErrorDescription: description})
+}
+
+// MemoryReplayCache is an in-memory ReplayCache for a single instance.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+
+	now func() time.Time
+}
+
+var _ ReplayCache = (*MemoryReplayCache)(nil)
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (c *MemoryReplayCache) Add(_ context.Context, jti string, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for k, exp := range c.entries {
+		if !now.Before(exp) {
+			delete(c.entries, k)
+		}
+	}
+
+	if _, ok := c.entries[jti]; ok {
+		return false, nil
+	}
+	c.entries[jti] = expiresAt
+	return true, nil
+}
+
+func (c *MemoryReplayCache) Remove(_ context.Context, jti string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, jti)
+	return nil
+}
+
+// isLogoutTokenType reports whether typ is the "typ" header of a logout
+// token: "logout+jwt", its full media type "application/logout+jwt", or
+// "JWT". Media types are compared case-insensitively.
+//
+// See: https://datatracker.ietf.org/doc/html/rfc8725#section-3.11
+func isLogoutTokenType(typ interface{}) bool {
+	s, ok := typ.(string)
+	if !ok {
+		return false
+	}
+	if len(s) > len("application/") && strings.EqualFold(s[:len("application/")], "application/") {
+		s = s[len("application/"):]
+	}
+	return strings.EqualFold(s, LogoutTokenType) || strings.EqualFold(s, "JWT")
+}
@@ -3,13 +3,19 @@ package oidc
 import (
 	"fmt"
 	"log"
-	"strings"
 
 	"math/big"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 
 	"encoding/base64"
 	"encoding/json"
@@ -21,6 +27,51 @@ type MockOidcServer struct {
 	issuerURL  string
 	privateKey *rsa.PrivateKey
 	publicKey  *rsa.PublicKey
+
+	server *http.Server
+
+	// userInfoSubject, when set, overrides the "sub" of UserInfo responses.
+	userInfoSubject atomic.Value // string
+	// signUserInfo returns UserInfo responses as signed JWTs.
+	signUserInfo atomic.Bool
+	// userInfoRequests counts UserInfo requests.
+	userInfoRequests atomic.Int32
+	// userInfoUnavailable answers UserInfo requests with 503.
+	userInfoUnavailable atomic.Bool
+
+	// claimsRequests counts distributed claims requests.
+	claimsRequests atomic.Int32
+
+	// jwksRequests counts key set requests.
+	jwksRequests atomic.Int32
+	// jwksEmpty serves a key set without keys.
+	jwksEmpty atomic.Bool
+
+	// discoveryIssuer, when set, overrides the "issuer" of the discovery
+	// document.
+	discoveryIssuer atomic.Value // string
+
+	// tokenRequests counts client credentials token requests.
+	tokenRequests atomic.Int32
+	// tokenRelease, when set, holds client credentials token requests
+	// until it is closed.
+	tokenRelease atomic.Value // chan struct{}
+	// tokenNoExpiry omits "expires_in" from client credentials tokens.
+	tokenNoExpiry atomic.Bool
+
+	// revoked holds the revoked tokens.
+	revoked sync.Map // token -> token_type_hint
+
+	// authCodes holds the pending authorization codes.
+	authCodes sync.Map // code -> mockAuthCode
+}
+
+// mockAuthCode is an authorization code issued by the authorize endpoint.
+type mockAuthCode struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
 }
 
 const kidHeader = "1"
@@ -37,37 +88,76 @@ func NewMockOidcServer(issuerURL string) (*MockOidcServer, error) {
 		publicKey:  privateKey.Public().(*rsa.PublicKey),
 	}
 
-	mockServer.start()
+	if err = mockServer.start(); err != nil {
+		return nil, err
+	}
 	return mockServer, nil
 }
 
-func (server MockOidcServer) start() {
-	http.HandleFunc("/.well-known/openid-configuration", server.handleGetConfiguration)
-	http.HandleFunc("/oidc/jwks", server.handleGetJWKS)
-	http.HandleFunc("/oauth2/token", server.handleGetToken)
-	http.HandleFunc("/oidc/userinfo", server.handleGetUserInfo)
+// start serves the endpoints on the host and port of the issuer URL. Each
+// server has its own mux, so several servers can run in one process.
+func (server *MockOidcServer) start() error {
+	u, err := url.Parse(server.issuerURL)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", server.handleGetConfiguration)
+	mux.HandleFunc("/oidc/jwks", server.handleGetJWKS)
+	mux.HandleFunc("/oidc/authorize", server.handleAuthorize)
+	mux.HandleFunc("/oauth2/token", server.handleGetToken)
+	mux.HandleFunc("/oauth2/revoke", server.handleRevoke)
+	mux.HandleFunc("/oidc/userinfo", server.handleGetUserInfo)
+	mux.HandleFunc("/oidc/claims", server.handleGetClaims)
 
-	port := strings.Split(server.issuerURL, ":")[2]
+	server.server = &http.Server{Handler: mux}
 	go func() {
-		log.Fatal(http.ListenAndServe(":"+port, nil))
+		_ = server.server.Serve(listener)
 	}()
+
+	return nil
+}
+
+// Close stops the server.
+func (server *MockOidcServer) Close() error {
+	return server.server.Close()
 }
 
-func (server MockOidcServer) handleGetConfiguration(w http.ResponseWriter, _ *http.Request) {
-	err := json.NewEncoder(w).Encode(map[string]string{
-		"issuer":                 server.issuerURL,
+func (server *MockOidcServer) handleGetConfiguration(w http.ResponseWriter, _ *http.Request) {
+	issuer := server.issuerURL
+	if override, _ := server.discoveryIssuer.Load().(string); override != "" {
+		issuer = override
+	}
+
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                 issuer,
 		"jwks_uri":               fmt.Sprintf("%s/oidc/jwks", server.issuerURL),
 		"revocation_endpoint":    fmt.Sprintf("%s/oauth2/revoke", server.issuerURL),
 		"token_endpoint":         fmt.Sprintf("%s/oauth2/token", server.issuerURL),
 		"authorization_endpoint": fmt.Sprintf("%s/oidc/authorize", server.issuerURL),
 		"userinfo_endpoint":      fmt.Sprintf("%s/oidc/userinfo", server.issuerURL),
+
+		"id_token_signing_alg_values_supported": []string{"RS256"},
 	})
 	if err != nil {
 		log.Fatalf("failed to json encode the openid configurations: %v", err)
 	}
 }
 
-func (server MockOidcServer) handleGetJWKS(w http.ResponseWriter, _ *http.Request) {
+func (server *MockOidcServer) handleGetJWKS(w http.ResponseWriter, _ *http.Request) {
+	server.jwksRequests.Add(1)
+
+	if server.jwksEmpty.Load() {
+		_, _ = w.Write([]byte(`{"keys":[]}`))
+		return
+	}
+
 	err := json.NewEncoder(w).Encode(map[string]interface{}{
 		"keys": []map[string]string{
 			{
@@ -85,13 +175,47 @@ func (server MockOidcServer) handleGetJWKS(w http.ResponseWriter, _ *http.Reques
 	}
 }
 
-func (server MockOidcServer) handleGetToken(w http.ResponseWriter, _ *http.Request) {
+// handleAuthorize logs the user in without asking and redirects back with
+// an authorization code.
+func (server *MockOidcServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	code := base64.RawURLEncoding.EncodeToString(b)
+	server.authCodes.Store(code, mockAuthCode{
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	})
+
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{
+		"code":  {code},
+		"state": {q.Get("state")},
+	}.Encode(), http.StatusFound)
+}
+
+func (server *MockOidcServer) handleGetToken(w http.ResponseWriter, r *http.Request) {
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		server.exchangeCode(w, r)
+		return
+	case "client_credentials":
+		server.issueClientToken(w, r)
+		return
+	}
+
 	var err error
 	token, err := server.GetToken("kratos.dev", "user")
 
-	err = json.NewEncoder(w).Encode(map[string]string{
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
 		"token_type":    "Bearer",
-		"expires_in":    "3600",
+		"expires_in":    3600,
 		"access_token":  "8xLOxBtZp8",
 		"refresh_token": "8xLOxBtZp8",
 		"id_token":      token,
@@ -101,11 +225,205 @@ func (server MockOidcServer) handleGetToken(w http.ResponseWriter, _ *http.Reque
 	}
 }
 
-func (server MockOidcServer) handleGetUserInfo(_ http.ResponseWriter, _ *http.Request) {
+// exchangeCode redeems an authorization code, checking the PKCE verifier.
+func (server *MockOidcServer) exchangeCode(w http.ResponseWriter, r *http.Request) {
+	v, ok := server.authCodes.LoadAndDelete(r.PostFormValue("code"))
+	if !ok {
+		server.tokenError(w, "invalid_grant")
+		return
+	}
+	code := v.(mockAuthCode)
+
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if clientID != code.clientID ||
+		r.PostFormValue("redirect_uri") != code.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != code.codeChallenge {
+		server.tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	accessToken, err := server.SignClaims(jwtV5.MapClaims{
+		"iss": server.issuerURL,
+		"sub": "user",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	atHash := sha256.Sum256([]byte(accessToken))
+
+	idToken, err := server.SignClaims(jwtV5.MapClaims{
+		"iss":     server.issuerURL,
+		"aud":     code.clientID,
+		"sub":     "user",
+		"nonce":   code.nonce,
+		"at_hash": base64.RawURLEncoding.EncodeToString(atHash[:len(atHash)/2]),
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"access_token": accessToken,
+		"id_token":     idToken,
+	})
 }
 
-func (server MockOidcServer) GetToken(audience, subject string) (string, error) {
+// issueClientToken issues an access token to a confidential client, with
+// its audience and resource parameters as "aud".
+func (server *MockOidcServer) issueClientToken(w http.ResponseWriter, r *http.Request) {
+	server.tokenRequests.Add(1)
+
+	if release, _ := server.tokenRelease.Load().(chan struct{}); release != nil {
+		<-release
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || secret == "" {
+		server.tokenError(w, "invalid_client")
+		return
+	}
+
+	aud := append(r.PostForm["audience"], r.PostForm["resource"]...)
+	accessToken, err := server.SignClaims(jwtV5.MapClaims{
+		"iss":   server.issuerURL,
+		"sub":   clientID,
+		"aud":   aud,
+		"scope": r.PostFormValue("scope"),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := map[string]interface{}{
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"access_token": accessToken,
+	}
+	if server.tokenNoExpiry.Load() {
+		delete(res, "expires_in")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (server *MockOidcServer) tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// handleRevoke revokes a token of an authenticated client.
+func (server *MockOidcServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); !ok && r.PostFormValue("client_id") == "" {
+		server.tokenError(w, "invalid_client")
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		server.tokenError(w, "invalid_request")
+		return
+	}
+	server.revoked.Store(token, r.PostFormValue("token_type_hint"))
+}
+
+func (server *MockOidcServer) handleGetUserInfo(w http.ResponseWriter, r *http.Request) {
+	server.userInfoRequests.Add(1)
+
+	if server.userInfoUnavailable.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// The access tokens of the mock are JWTs signed by the server.
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, revoked := server.revoked.Load(accessToken); revoked {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	claims := jwtV5.MapClaims{}
+	if _, err := jwtV5.ParseWithClaims(accessToken, claims, func(*jwtV5.Token) (interface{}, error) {
+		return server.publicKey, nil
+	}); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sub, _ := claims.GetSubject()
+	if override, _ := server.userInfoSubject.Load().(string); override != "" {
+		sub = override
+	}
+
+	userInfo := jwtV5.MapClaims{
+		"sub":    sub,
+		"name":   "Kratos User",
+		"email":  sub + "@kratos.dev",
+		"groups": []string{"admin", "dev"},
+	}
+
+	if server.signUserInfo.Load() {
+		userInfo["iss"] = server.issuerURL
+		userInfo["aud"] = claims["aud"]
+		userInfo["iat"] = time.Now().Unix()
+		token, err := server.SignClaims(userInfo)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jwt")
+		_, _ = w.Write([]byte(token))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(userInfo); err != nil {
+		log.Fatalf("failed to json encode the userinfo: %v", err)
+	}
+}
+
+// ClaimsSourceAccessToken is the access token accepted by the distributed
+// claims endpoint of the mock.
+const ClaimsSourceAccessToken = "claims-source-token"
+
+// handleGetClaims serves distributed claims about the subject named by the
+// "sub" query parameter as a signed JWT.
+func (server *MockOidcServer) handleGetClaims(w http.ResponseWriter, r *http.Request) {
+	server.claimsRequests.Add(1)
+
+	if r.Header.Get("Authorization") != "Bearer "+ClaimsSourceAccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	token, err := server.SignClaims(jwtV5.MapClaims{
+		"iss":    server.issuerURL,
+		"sub":    r.URL.Query().Get("sub"),
+		"groups": []string{"distributed-group-1", "distributed-group-2"},
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwt")
+	_, _ = w.Write([]byte(token))
+}
+
+func (server *MockOidcServer) GetToken(audience, subject string) (string, error) {
 	token := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, jwtV5.RegisteredClaims{
 		Issuer:   server.issuerURL,
 		Audience: []string{audience},
@@ -114,3 +432,11 @@ func (server MockOidcServer) GetToken(audience, subject string) (string, error)
 	token.Header["kid"] = kidHeader
 	return token.SignedString(server.privateKey)
 }
+
+// SignClaims signs arbitrary claims with the server key, e.g. to build ID
+// tokens with "nonce", "azp" or "at_hash".
+func (server *MockOidcServer) SignClaims(claims jwtV5.Claims) (string, error) {
+	token := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, claims)
+	token.Header["kid"] = kidHeader
+	return token.SignedString(server.privateKey)
+}
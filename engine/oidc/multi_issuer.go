@@ -0,0 +1,127 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+var _ engine.Authenticator = (*MultiIssuerAuthenticator)(nil)
+
+// MultiIssuerAuthenticator accepts tokens from several OpenID Connect
+// providers. The unverified "iss" claim selects the provider, which then
+// verifies the token with its own discovery document, JWKS, audience and
+// claim mapping. Tokens from unknown issuers are rejected without any
+// network call.
+type MultiIssuerAuthenticator struct {
+	providers map[string]*Authenticator
+}
+
+// MultiIssuerOption configures a MultiIssuerAuthenticator.
+type MultiIssuerOption func(m *MultiIssuerAuthenticator) error
+
+// WithProvider adds a provider configured like a single-issuer
+// Authenticator; WithIssuerURL is required and must be unique.
+func WithProvider(opts ...Option) MultiIssuerOption {
+	return func(m *MultiIssuerAuthenticator) error {
+		provider, err := newAuthenticator(opts...)
+		if err != nil {
+			return err
+		}
+
+		issuer := provider.options.IssuerURL
+		if _, ok := m.providers[issuer]; ok {
+			provider.Close()
+			return fmt.Errorf("duplicate issuer %q", issuer)
+		}
+		m.providers[issuer] = provider
+		return nil
+	}
+}
+
+func NewMultiIssuerAuthenticator(opts ...MultiIssuerOption) (engine.Authenticator, error) {
+	m := &MultiIssuerAuthenticator{
+		providers: make(map[string]*Authenticator),
+	}
+
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+
+	if len(m.providers) == 0 {
+		return nil, errors.New("at least one provider is required")
+	}
+
+	return m, nil
+}
+
+// Provider returns the authenticator of the issuer.
+func (m *MultiIssuerAuthenticator) Provider(issuer string) (*Authenticator, bool) {
+	p, ok := m.providers[issuer]
+	return p, ok
+}
+
+func (m *MultiIssuerAuthenticator) Authenticate(requestContext context.Context, contextType engine.ContextType) (*engine.AuthClaims, error) {
+	tokenString, err := engine.AuthFromMD(requestContext, engine.BearerWord, contextType)
+	if err != nil {
+		return nil, engine.ErrMissingBearerToken
+	}
+
+	return m.authenticateToken(requestContext, tokenString)
+}
+
+func (m *MultiIssuerAuthenticator) AuthenticateToken(token string) (*engine.AuthClaims, error) {
+	return m.authenticateToken(context.Background(), token)
+}
+
+// authenticateToken verifies the token with the provider of its issuer,
+// passing ctx on to UserInfo and claim source requests.
+func (m *MultiIssuerAuthenticator) authenticateToken(ctx context.Context, token string) (*engine.AuthClaims, error) {
+	issuer, err := unverifiedIssuer(token)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, ok := m.providers[issuer]
+	if !ok {
+		return nil, engine.ErrInvalidIssuer
+	}
+
+	return provider.authenticateToken(ctx, token)
+}
+
+func (m *MultiIssuerAuthenticator) CreateIdentityWithContext(ctx context.Context, _ engine.ContextType, _ engine.AuthClaims) (context.Context, error) {
+	return ctx, nil
+}
+
+func (m *MultiIssuerAuthenticator) CreateIdentity(_ engine.AuthClaims) (string, error) {
+	return "", nil
+}
+
+func (m *MultiIssuerAuthenticator) Close() {
+	for _, p := range m.providers {
+		p.Close()
+	}
+}
+
+// unverifiedIssuer returns the "iss" claim of a token without verifying it.
+// It is only used to select the provider that then verifies the token.
+func unverifiedIssuer(token string) (string, error) {
+	claims := jwtV5.MapClaims{}
+	if _, _, err := jwtV5.NewParser().ParseUnverified(token, claims); err != nil {
+		return "", engine.ErrInvalidToken
+	}
+
+	issuer, err := claims.GetIssuer()
+	if err != nil || issuer == "" {
+		return "", engine.ErrInvalidIssuer
+	}
+	return issuer, nil
+}
@@ -2,25 +2,24 @@ package oidc
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	keyfuncV3 "github.com/MicahParks/keyfunc/v3"
 	jwtV5 "github.com/golang-jwt/jwt/v5"
 
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/go-kratos/kratos/v2/log"
 
 	"github.com/tx7do/kratos-authn/engine"
 )
 
-//var (
-//	jwkRefreshInterval, _ = time.ParseDuration("48h")
-//)
-
 var _ engine.Authenticator = (*Authenticator)(nil)
 var _ Configurator = (*Authenticator)(nil)
 
@@ -30,36 +29,187 @@ type Authenticator struct {
 	JwksURI string
 	JWKs    keyfuncV3.Keyfunc
 
-	signingMethod jwtV5.SigningMethod
+	// algorithms are the signing algorithms accepted when verifying tokens.
+	algorithms []string
+
+	// providerConfig is the discovery document of the provider.
+	providerConfig *ProviderConfig
+
+	// userInfoCache, when set, enriches verified claims with UserInfo claims.
+	userInfoCache *claimsCache
+
+	// claimSourceCache, when set, caches resolved aggregated and distributed
+	// claims.
+	claimSourceCache *claimsCache
 
 	httpClient *http.Client
+
+	// tokenSource, when set, issues the tokens of CreateIdentity.
+	tokenSource *ClientCredentialsTokenSource
+
+	// ready is closed once the provider fields above are set; see checkReady.
+	ready     chan struct{}
+	readyOnce sync.Once
+	keys      *keySet
+
+	// remoteKeys is the key set fetched from the provider, once discovered.
+	remoteKeys atomic.Pointer[remoteKeySet]
+
+	// ctx ends background discovery and key refreshes on Close.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
 }
 
 func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
+	return newAuthenticator(opts...)
+}
+
+// NewAuthenticatorFromConfig creates an authenticator for a provider that
+// does not support discovery, or without reaching it at all: config
+// replaces the discovery document, and jwksJSON, when not empty, is the key
+// set of the provider. Tokens must be issued by config.Issuer.
+func NewAuthenticatorFromConfig(config ProviderConfig, jwksJSON []byte, opts ...Option) (engine.Authenticator, error) {
+	opts = append(opts, WithProviderConfig(config))
+	if len(jwksJSON) > 0 {
+		opts = append(opts, WithJWKS(jwksJSON))
+	}
+	return newAuthenticator(opts...)
+}
+
+func newAuthenticator(opts ...Option) (*Authenticator, error) {
 	oidc := &Authenticator{
-		options:    &Options{},
-		httpClient: retryablehttp.NewClient().StandardClient(),
+		options: &Options{
+			discoveryRetryMin: DefaultDiscoveryRetryMin,
+			discoveryRetryMax: DefaultDiscoveryRetryMax,
+
+			jwksRefreshInterval:           DefaultJWKSRefreshInterval,
+			jwksUnknownKIDRefreshInterval: DefaultJWKSUnknownKIDRefreshInterval,
+			jwksRequestTimeout:            DefaultJWKSRequestTimeout,
+
+			retryMax:     DefaultRetryMax,
+			retryWaitMin: DefaultRetryWaitMin,
+			retryWaitMax: DefaultRetryWaitMax,
+
+			log: log.NewHelper(log.With(log.DefaultLogger, "module", "authn.oidc")),
+		},
+		ready: make(chan struct{}),
+		keys:  &keySet{},
 	}
 
 	for _, o := range opts {
-		o(oidc.options)
+		if err := o(oidc.options); err != nil {
+			return nil, err
+		}
 	}
 
-	if oidc.options.signingMethod == nil {
-		oidc.options.signingMethod = jwtV5.SigningMethodRS256
+	if err := oidc.options.validate(); err != nil {
+		return nil, err
 	}
 
-	if err := oidc.fetchKeys(); err != nil {
+	httpClient, err := oidc.options.newHTTPClient()
+	if err != nil {
 		return nil, err
 	}
+	oidc.httpClient = httpClient
+
+	if oidc.options.userInfoEnrichment {
+		oidc.userInfoCache = newClaimsCache(oidc.options.userInfoCacheTTL)
+	}
+	if oidc.options.resolveClaimSources {
+		oidc.claimSourceCache = newClaimsCache(DefaultClaimSourceCacheTTL)
+	}
+
+	if oidc.options.clientID != "" {
+		oidc.tokenSource, err = oidc.NewClientCredentialsTokenSource(
+			oidc.options.clientID, oidc.options.clientSecret, oidc.options.clientTokenOptions...)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	//fmt.Println(oidc.JWKs.KIDs())
+	oidc.ctx, oidc.cancel = context.WithCancel(context.Background())
+
+	if oidc.options.providerConfig != nil {
+		if err = oidc.configure(); err != nil {
+			oidc.cancel()
+			return nil, err
+		}
+		return oidc, nil
+	}
+
+	// A warm start serves the cached provider right away and refreshes it in
+	// the background; so does lazy discovery once the provider is reachable.
+	warm := oidc.options.discoveryCachePath != "" && oidc.loadDiscoveryCache() == nil
+	if warm || oidc.options.lazyDiscovery {
+		oidc.background.Add(1)
+		go oidc.discoverInBackground()
+		return oidc, nil
+	}
+
+	if err = oidc.discover(); err != nil {
+		oidc.cancel()
+		return nil, err
+	}
 
 	return oidc, nil
 }
 
 func (a *Authenticator) parseToken(token string) (*jwtV5.Token, error) {
-	return jwtV5.Parse(token, a.JWKs.Keyfunc)
+	return jwtV5.Parse(token, a.JWKs.Keyfunc, jwtV5.WithValidMethods(a.algorithms))
+}
+
+// isAllowedAlgorithm reports whether alg is in the allowed list.
+func (a *Authenticator) isAllowedAlgorithm(alg string) bool {
+	for _, m := range a.algorithms {
+		if m == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAlgorithms returns the configured algorithms, or else the
+// algorithms advertised by the provider that this package supports. A
+// provider that advertises none is assumed to sign with RS256, the default
+// of OpenID Connect Discovery.
+func resolveAlgorithms(configured, advertised []string) ([]string, error) {
+	if len(configured) > 0 {
+		return configured, nil
+	}
+	if len(advertised) == 0 {
+		return []string{RS256}, nil
+	}
+
+	var algs []string
+	for _, alg := range advertised {
+		if SupportedAlgorithms[alg] {
+			algs = append(algs, alg)
+		}
+	}
+	if len(algs) == 0 {
+		return nil, fmt.Errorf("%w: provider advertises none of the supported algorithms: %v", engine.ErrUnsupportedSigningMethod, advertised)
+	}
+	return algs, nil
+}
+
+// tokenAlgorithm returns the unverified "alg" header of a compact JWS.
+func tokenAlgorithm(token string) (string, error) {
+	seg, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", engine.ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return "", engine.ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(raw, &header); err != nil {
+		return "", engine.ErrInvalidToken
+	}
+	return header.Alg, nil
 }
 
 func (a *Authenticator) Authenticate(requestContext context.Context, contextType engine.ContextType) (*engine.AuthClaims, error) {
@@ -77,10 +227,27 @@ func (a *Authenticator) Authenticate(requestContext context.Context, contextType
 	//	return nil, engine.ErrInvalidToken
 	//}
 
-	return a.AuthenticateToken(tokenString)
+	return a.authenticateToken(requestContext, tokenString)
 }
 
 func (a *Authenticator) AuthenticateToken(token string) (*engine.AuthClaims, error) {
+	return a.authenticateToken(context.Background(), token)
+}
+
+func (a *Authenticator) authenticateToken(ctx context.Context, token string) (*engine.AuthClaims, error) {
+	if err := a.checkReady(); err != nil {
+		return nil, err
+	}
+
+	// Reject "none", HMAC and unlisted algorithms before any key lookup.
+	alg, err := tokenAlgorithm(token)
+	if err != nil {
+		return nil, err
+	}
+	if !a.isAllowedAlgorithm(alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+
 	jwtToken, err := a.parseToken(token)
 
 	if jwtToken == nil {
@@ -88,16 +255,7 @@ func (a *Authenticator) AuthenticateToken(token string) (*engine.AuthClaims, err
 	}
 
 	if err != nil {
-		switch {
-		case errors.Is(err, jwtV5.ErrTokenMalformed):
-			return nil, engine.ErrInvalidToken
-		case errors.Is(err, jwtV5.ErrTokenSignatureInvalid):
-			return nil, engine.ErrSignTokenFailed
-		case errors.Is(err, jwtV5.ErrTokenExpired) || errors.Is(err, jwtV5.ErrTokenNotValidYet):
-			return nil, engine.ErrTokenExpired
-		default:
-			return nil, engine.ErrInvalidToken
-		}
+		return nil, mapParseError(err)
 	}
 
 	if !jwtToken.Valid {
@@ -115,66 +273,101 @@ func (a *Authenticator) AuthenticateToken(token string) (*engine.AuthClaims, err
 	validator := jwtV5.NewValidator(opts...)
 	err = validator.Validate(claims)
 	if err != nil {
-		switch {
-		case errors.Is(err, jwtV5.ErrTokenInvalidAudience):
-			return nil, engine.ErrInvalidAudience
-		case errors.Is(err, jwtV5.ErrTokenInvalidIssuer):
-			return nil, engine.ErrInvalidIssuer
-		default:
-			return nil, engine.ErrInvalidToken
-		}
+		return nil, mapParseError(err)
 	}
 
 	authClaim := engine.AuthClaims(claims)
 
+	if a.options.resolveClaimSources {
+		if err = a.resolveClaims(ctx, token, authClaim); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.userInfoCache != nil {
+		if err = a.enrichClaims(ctx, token, authClaim); err != nil {
+			return nil, err
+		}
+	}
+
+	a.options.mapClaims(authClaim)
+
 	return &authClaim, nil
 }
 
-func (a *Authenticator) CreateIdentityWithContext(ctx context.Context, _ engine.ContextType, _ engine.AuthClaims) (context.Context, error) {
-	return ctx, nil
+// CreateIdentityWithContext sets an access token of the service itself to
+// the context, if configured with WithClientCredentials; the claims are
+// ignored, as the provider issues the token. Otherwise it does nothing.
+func (a *Authenticator) CreateIdentityWithContext(ctx context.Context, contextType engine.ContextType, _ engine.AuthClaims) (context.Context, error) {
+	if a.tokenSource == nil {
+		return ctx, nil
+	}
+
+	token, err := a.tokenSource.Token(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return engine.MDWithAuth(ctx, engine.BearerWord, token.AccessToken, contextType), nil
 }
 
+// CreateIdentity returns an empty token: tokens are issued by the provider,
+// not minted from claims. The access token of the service itself is only
+// attached to outbound calls by CreateIdentityWithContext, or read from
+// ClientCredentials, so that it cannot end up in a reply to a user.
 func (a *Authenticator) CreateIdentity(_ engine.AuthClaims) (string, error) {
 	return "", nil
 }
 
-func (a *Authenticator) Close() {
-	//a.JWKs.EndBackground()
+// ClientCredentials returns the token source configured with
+// WithClientCredentials, or nil.
+func (a *Authenticator) ClientCredentials() *ClientCredentialsTokenSource {
+	return a.tokenSource
 }
 
-func (a *Authenticator) fetchKeys() error {
-	oidcConfig, err := a.GetConfiguration()
-	if err != nil {
-		return fmt.Errorf("error fetching OIDC configuration: %w", err)
+// Close stops background discovery and key refreshes, and waits for them
+// to end.
+func (a *Authenticator) Close() {
+	a.cancel()
+	a.background.Wait()
+	if k := a.remoteKeys.Load(); k != nil {
+		k.Close()
 	}
+}
 
-	a.JwksURI = oidcConfig.JWKSURL
-
-	jwks, err := a.GetKeyfunc()
-	if err != nil {
-		return fmt.Errorf("error fetching OIDC keys: %w", err)
+// GetKeyfunc returns the key set of the provider.
+func (a *Authenticator) GetKeyfunc() (keyfuncV3.Keyfunc, error) {
+	if err := a.checkReady(); err != nil {
+		return nil, err
 	}
-
-	a.JWKs = jwks
-
-	return nil
+	return a.JWKs, nil
 }
 
-func (a *Authenticator) GetKeyfunc() (keyfuncV3.Keyfunc, error) {
-	jwks, err := keyfuncV3.NewDefault([]string{a.JwksURI})
-	if err != nil {
-		return nil, fmt.Errorf("error fetching keys from %v: %w", a.JwksURI, err)
+// JWKSStatus returns the state of the provider key set. Until the key set
+// was fetched from the provider, LastError is engine.ErrProviderUnavailable.
+func (a *Authenticator) JWKSStatus() JWKSStatus {
+	if k := a.remoteKeys.Load(); k != nil {
+		return k.Status()
+	}
+	if a.options.jwks != nil {
+		return JWKSStatus{Keys: jwksKeyCount(a.options.jwks)}
 	}
-	return jwks, nil
+	return JWKSStatus{LastError: engine.ErrProviderUnavailable}
 }
 
 func (a *Authenticator) getDiscoveryUri() string {
 	return strings.TrimSuffix(a.options.IssuerURL, "/") + "/.well-known/openid-configuration"
 }
 
+// GetConfiguration fetches the discovery document of the provider, or
+// returns the static configuration if discovery is skipped.
 func (a *Authenticator) GetConfiguration() (*ProviderConfig, error) {
+	if a.options.providerConfig != nil {
+		config := *a.options.providerConfig
+		return &config, nil
+	}
+
 	wellKnown := a.getDiscoveryUri()
-	req, err := http.NewRequest("GET", wellKnown, nil)
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodGet, wellKnown, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error forming request to get OIDC: %w", err)
 	}
@@ -184,9 +377,8 @@ func (a *Authenticator) GetConfiguration() (*ProviderConfig, error) {
 		return nil, fmt.Errorf("error getting OIDC: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
-		err = Body.Close()
-		if err != nil {
-			fmt.Println(err)
+		if err := Body.Close(); err != nil {
+			a.options.log.Warnf("closing OIDC configuration response failed: %s", err.Error())
 		}
 	}(res.Body)
 
@@ -208,6 +400,13 @@ func (a *Authenticator) GetConfiguration() (*ProviderConfig, error) {
 		return nil, errors.New("missing issuer value")
 	}
 
+	// The document must be about the configured issuer, not whatever a
+	// redirect or a compromised URL served.
+	// See: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if oidcConfig.Issuer != a.options.IssuerURL {
+		return nil, fmt.Errorf("issuer %q of the discovery document does not match %q", oidcConfig.Issuer, a.options.IssuerURL)
+	}
+
 	if oidcConfig.JWKSURL == "" {
 		return nil, errors.New("missing jwks_uri value")
 	}
@@ -2,14 +2,31 @@ package oidc
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/transport"
+	kratosHttp "github.com/go-kratos/kratos/v2/transport/http"
+	jwtV5 "github.com/golang-jwt/jwt/v5"
 
 	"github.com/tx7do/kratos-authn/engine"
 )
@@ -64,6 +81,7 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 
 	trustedIssuerServer, err := NewMockOidcServer(localOIDCServerURL)
 	require.NoError(t, err)
+	defer trustedIssuerServer.Close()
 
 	auth, err := NewAuthenticator(
 		WithIssuerURL(localOIDCServerURL),
@@ -101,6 +119,7 @@ func TestBuildServerWithOIDCAuthentication(t *testing.T) {
 
 	trustedIssuerServer, err := NewMockOidcServer(localOIDCServerURL)
 	require.NoError(t, err)
+	defer trustedIssuerServer.Close()
 
 	trustedToken, err := trustedIssuerServer.GetToken(audience, "user_name")
 	require.NoError(t, err)
@@ -132,3 +151,1477 @@ func TestBuildServerWithOIDCAuthentication(t *testing.T) {
 	assert.NotNil(t, cfg)
 	fmt.Printf("%v\n", cfg)
 }
+
+func TestNewAuthenticator_InvalidConfiguration(t *testing.T) {
+	_, err := NewAuthenticator(WithAudience("kratos.dev"))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithIssuerURL("localhost:8083"))
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithIssuerURL("http://localhost:8083"), WithSigningMethod("HS256"))
+	assert.ErrorIs(t, err, engine.ErrUnsupportedSigningMethod)
+}
+
+func TestAuthenticator_AllowedAlgorithms(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// The mock advertises RS256 only.
+	auth, err := NewAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	token, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+
+	claims := jwtV5.RegisteredClaims{Issuer: localOIDCServerURL, Audience: []string{audience}, Subject: "user_name"}
+
+	// HMAC signed with the public modulus as the secret (algorithm confusion).
+	hsToken, err := jwtV5.NewWithClaims(jwtV5.SigningMethodHS256, claims).SignedString(server.publicKey.N.Bytes())
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(hsToken)
+	assert.Equal(t, engine.ErrUnsupportedSigningMethod, err)
+
+	noneToken, err := jwtV5.NewWithClaims(jwtV5.SigningMethodNone, claims).SignedString(jwtV5.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(noneToken)
+	assert.Equal(t, engine.ErrUnsupportedSigningMethod, err)
+
+	rs384 := jwtV5.NewWithClaims(jwtV5.SigningMethodRS384, claims)
+	rs384.Header["kid"] = kidHeader
+	rs384Token, err := rs384.SignedString(server.privateKey)
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(rs384Token)
+	assert.Equal(t, engine.ErrUnsupportedSigningMethod, err)
+
+	// A configured list overrides the advertised one.
+	restricted, err := NewAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithAllowedAlgorithms(ES256),
+	)
+	require.NoError(t, err)
+	defer restricted.Close()
+
+	_, err = restricted.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrUnsupportedSigningMethod, err)
+}
+
+func TestResolveAlgorithms(t *testing.T) {
+	algs, err := resolveAlgorithms([]string{ES256}, []string{RS256})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ES256}, algs)
+
+	algs, err = resolveAlgorithms(nil, []string{"none", "HS256", RS256, PS256})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RS256, PS256}, algs)
+
+	algs, err = resolveAlgorithms(nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RS256}, algs)
+
+	_, err = resolveAlgorithms(nil, []string{"none", "HS256"})
+	assert.ErrorIs(t, err, engine.ErrUnsupportedSigningMethod)
+}
+
+func TestMultiIssuerAuthenticator(t *testing.T) {
+	const corporateURL = "http://localhost:8083"
+	const customerURL = "http://localhost:8084"
+
+	corporate, err := NewMockOidcServer(corporateURL)
+	require.NoError(t, err)
+	defer corporate.Close()
+
+	customer, err := NewMockOidcServer(customerURL)
+	require.NoError(t, err)
+	defer customer.Close()
+
+	auth, err := NewMultiIssuerAuthenticator(
+		WithProvider(WithIssuerURL(corporateURL), WithAudience("api.corp")),
+		WithProvider(
+			WithIssuerURL(customerURL),
+			WithAudience("api.customer"),
+			WithClaimMapping(map[string]string{"sub": "customer_id"}),
+		),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	token, err := corporate.GetToken("api.corp", "employee")
+	require.NoError(t, err)
+	claims, err := auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "employee", sub)
+	_, mapped := (*claims)["customer_id"]
+	assert.False(t, mapped)
+
+	token, err = customer.GetToken("api.customer", "customer")
+	require.NoError(t, err)
+	claims, err = auth.AuthenticateToken(token)
+	assert.Nil(t, err)
+	customerID, _ := claims.GetString("customer_id")
+	assert.Equal(t, "customer", customerID)
+
+	// Each provider checks its own audience.
+	token, err = customer.GetToken("api.corp", "customer")
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrInvalidAudience, err)
+
+	// A key of one provider cannot sign for another issuer.
+	forged := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, jwtV5.RegisteredClaims{
+		Issuer: corporateURL, Audience: []string{"api.corp"}, Subject: "employee",
+	})
+	forged.Header["kid"] = kidHeader
+	token, err = forged.SignedString(customer.privateKey)
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrSignTokenFailed, err)
+
+	// Unknown issuers are rejected up front.
+	unknown := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, jwtV5.RegisteredClaims{
+		Issuer: "http://localhost:1", Audience: []string{"api.corp"}, Subject: "employee",
+	})
+	token, err = unknown.SignedString(customer.privateKey)
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrInvalidIssuer, err)
+
+	// The request context reaches the UserInfo request of the provider.
+	enriching, err := NewMultiIssuerAuthenticator(
+		WithProvider(WithIssuerURL(corporateURL), WithAudience("api.corp"), WithUserInfoEnrichment(time.Minute)),
+	)
+	require.NoError(t, err)
+	defer enriching.Close()
+
+	accessToken, err := corporate.SignClaims(jwtV5.MapClaims{
+		"iss": corporateURL,
+		"aud": "api.corp",
+		"sub": "employee",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	requestCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	requestCtx = transport.NewServerContext(requestCtx, &myTransporter{
+		reqHeader:   headerCarrier{"Authorization": []string{engine.BearerWord + " " + accessToken}},
+		replyHeader: headerCarrier{},
+	})
+	_, err = enriching.Authenticate(requestCtx, engine.ContextTypeKratosMetaData)
+	assert.Equal(t, engine.ErrUnauthenticated, err)
+	assert.Equal(t, int32(0), corporate.userInfoRequests.Load())
+
+	_, err = NewMultiIssuerAuthenticator(
+		WithProvider(WithIssuerURL(corporateURL)),
+		WithProvider(WithIssuerURL(corporateURL)),
+	)
+	assert.NotNil(t, err)
+
+	_, err = NewMultiIssuerAuthenticator()
+	assert.NotNil(t, err)
+}
+
+func TestIDTokenVerifier(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const clientID = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	auth, err := newAuthenticator(WithIssuerURL(localOIDCServerURL), WithAudience(clientID))
+	require.NoError(t, err)
+	defer auth.Close()
+
+	verifier := auth.IDTokenVerifier("")
+	ctx := context.Background()
+	now := time.Now()
+
+	// Without a client ID, the audience cannot be checked.
+	noAudience, err := newAuthenticator(WithIssuerURL(localOIDCServerURL))
+	require.NoError(t, err)
+	defer noAudience.Close()
+	anyToken, err := server.SignClaims(jwtV5.MapClaims{
+		"iss": localOIDCServerURL,
+		"aud": "other-client",
+		"sub": "user",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+	_, err = noAudience.IDTokenVerifier("").Verify(ctx, anyToken)
+	assert.Equal(t, ErrMissingClientID, err)
+
+	idClaims := func(modify func(c jwtV5.MapClaims)) jwtV5.MapClaims {
+		c := jwtV5.MapClaims{
+			"iss":     localOIDCServerURL,
+			"aud":     clientID,
+			"sub":     "user_name",
+			"exp":     now.Add(time.Hour).Unix(),
+			"iat":     now.Unix(),
+			"nonce":   "n-0S6_WzA2Mj",
+			"at_hash": "77QmUPtjPfzWtF2AnpK9RQ",
+			"email":   "user@kratos.dev",
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	raw, err := server.SignClaims(idClaims(nil))
+	require.NoError(t, err)
+
+	idToken, err := verifier.VerifyWithNonce(ctx, raw, "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+	assert.Equal(t, localOIDCServerURL, idToken.Issuer)
+	assert.Equal(t, []string{clientID}, idToken.Audience)
+	assert.Equal(t, "user_name", idToken.Subject)
+	assert.Equal(t, now.Add(time.Hour).Unix(), idToken.Expiry.Unix())
+	assert.Equal(t, now.Unix(), idToken.IssuedAt.Unix())
+	assert.Equal(t, "n-0S6_WzA2Mj", idToken.Nonce)
+
+	var extra struct {
+		Email string `json:"email"`
+	}
+	assert.Nil(t, idToken.Claims(&extra))
+	assert.Equal(t, "user@kratos.dev", extra.Email)
+
+	// at_hash example from OpenID Connect Core, appendix A.3.
+	assert.Nil(t, idToken.VerifyAccessToken("jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"))
+	assert.Equal(t, engine.ErrInvalidAtHash, idToken.VerifyAccessToken("other"))
+
+	tests := []struct {
+		name   string
+		modify func(c jwtV5.MapClaims)
+		nonce  string
+		want   error
+	}{
+		{"wrong nonce", nil, "other", engine.ErrInvalidNonce},
+		{"wrong issuer", func(c jwtV5.MapClaims) { c["iss"] = "http://localhost:1" }, "", engine.ErrInvalidIssuer},
+		{"wrong audience", func(c jwtV5.MapClaims) { c["aud"] = "other" }, "", engine.ErrInvalidAudience},
+		{"expired", func(c jwtV5.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, "", engine.ErrTokenExpired},
+		{"missing exp", func(c jwtV5.MapClaims) { delete(c, "exp") }, "", engine.ErrInvalidExpiration},
+		{"several audiences without azp", func(c jwtV5.MapClaims) { c["aud"] = []string{clientID, "other"} }, "", engine.ErrInvalidAudience},
+		{"azp of another client", func(c jwtV5.MapClaims) { c["azp"] = "other" }, "", engine.ErrInvalidAudience},
+		{"several audiences with azp", func(c jwtV5.MapClaims) { c["aud"] = []string{clientID, "other"}; c["azp"] = clientID }, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := server.SignClaims(idClaims(tt.modify))
+			require.NoError(t, err)
+			_, err = verifier.VerifyWithNonce(ctx, raw, tt.nonce)
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+func TestAuthenticator_GetUserInfo(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	auth, err := newAuthenticator(WithIssuerURL(localOIDCServerURL), WithAudience(audience))
+	require.NoError(t, err)
+	defer auth.Close()
+
+	accessToken, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+
+	for _, signed := range []bool{false, true} {
+		server.signUserInfo.Store(signed)
+
+		userInfo, err := auth.GetUserInfo(context.Background(), accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "user_name", userInfo.Subject)
+		assert.Equal(t, "Kratos User", userInfo.Name)
+		assert.Equal(t, "user_name@kratos.dev", userInfo.Email)
+
+		var extra struct {
+			Groups []string `json:"groups"`
+		}
+		assert.Nil(t, userInfo.Claims(&extra))
+		assert.Equal(t, []string{"admin", "dev"}, extra.Groups)
+	}
+
+	_, err = auth.GetUserInfo(context.Background(), "invalid")
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticator_UserInfoEnrichment(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	auth, err := NewAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithUserInfoEnrichment(time.Minute),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	accessToken, err := server.SignClaims(jwtV5.MapClaims{
+		"iss":  localOIDCServerURL,
+		"aud":  audience,
+		"sub":  "user_name",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+		"name": "Token Name",
+	})
+	require.NoError(t, err)
+
+	claims, err := auth.AuthenticateToken(accessToken)
+	require.NoError(t, err)
+
+	email, _ := claims.GetString("email")
+	assert.Equal(t, "user_name@kratos.dev", email)
+	groups, _ := claims.GetStrings("groups")
+	assert.Equal(t, []string{"admin", "dev"}, groups)
+
+	// Token claims take precedence.
+	name, _ := claims.GetString("name")
+	assert.Equal(t, "Token Name", name)
+
+	// The second verification is served from the cache.
+	_, err = auth.AuthenticateToken(accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), server.userInfoRequests.Load())
+
+	// A UserInfo response for another subject is rejected.
+	server.userInfoSubject.Store("someone_else")
+	otherToken, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(otherToken)
+	assert.Equal(t, engine.ErrInvalidSubject, err)
+}
+
+func TestAuthenticator_UserInfoFallback(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+	server.signUserInfo.Store(true)
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithUserInfoEnrichment(time.Minute),
+		WithRetryPolicy(0, 0, 0),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	newToken := func() string {
+		jti, err := randomString()
+		require.NoError(t, err)
+		token, err := server.SignClaims(jwtV5.MapClaims{
+			"iss": localOIDCServerURL,
+			"aud": audience,
+			"sub": "user_name",
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"jti": jti,
+		})
+		require.NoError(t, err)
+		return token
+	}
+
+	token := newToken()
+	claims, err := auth.AuthenticateToken(token)
+	require.NoError(t, err)
+	email, _ := claims.GetString("email")
+	assert.Equal(t, "user_name@kratos.dev", email)
+
+	// The envelope of the signed response is not merged.
+	_, ok := (*claims)["iat"]
+	assert.False(t, ok)
+
+	// While UserInfo is unavailable, stale claims are used,
+	auth.userInfoCache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	server.userInfoUnavailable.Store(true)
+
+	claims, err = auth.AuthenticateToken(token)
+	require.NoError(t, err)
+	email, _ = claims.GetString("email")
+	assert.Equal(t, "user_name@kratos.dev", email)
+
+	// or else the token claims alone.
+	claims, err = auth.AuthenticateToken(newToken())
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user_name", sub)
+	_, ok = (*claims)["email"]
+	assert.False(t, ok)
+
+	// Signed responses must be issued by the provider to the client.
+	for name, test := range map[string]struct {
+		claims jwtV5.MapClaims
+		err    error
+	}{
+		"other issuer":   {jwtV5.MapClaims{"sub": "user_name", "iss": "https://other.example", "aud": audience}, engine.ErrInvalidIssuer},
+		"no issuer":      {jwtV5.MapClaims{"sub": "user_name", "aud": audience}, engine.ErrInvalidIssuer},
+		"other audience": {jwtV5.MapClaims{"sub": "user_name", "iss": localOIDCServerURL, "aud": "other"}, engine.ErrInvalidAudience},
+		"no audience":    {jwtV5.MapClaims{"sub": "user_name", "iss": localOIDCServerURL}, engine.ErrInvalidAudience},
+	} {
+		signed, err := server.SignClaims(test.claims)
+		require.NoError(t, err)
+		_, err = auth.verifyUserInfoJWT(signed)
+		assert.Equal(t, test.err, err, name)
+	}
+}
+
+func TestAuthenticator_ResolveClaimSources(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// A claims endpoint on another host, which must never see the token.
+	var sourceAuthorization atomic.Value
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sourceAuthorization.Store(r.Header.Get("Authorization"))
+		token, err := server.SignClaims(jwtV5.MapClaims{
+			"iss":         localOIDCServerURL,
+			"sub":         "user_name",
+			"departments": []string{"engineering"},
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(token))
+	}))
+	defer source.Close()
+	sourceURL, err := url.Parse(source.URL)
+	require.NoError(t, err)
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithResolveClaimSources(true),
+		WithClaimSourceHosts(sourceURL.Host),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	aggregated, err := server.SignClaims(jwtV5.MapClaims{
+		"iss":   localOIDCServerURL,
+		"sub":   "user_name",
+		"roles": []string{"reader"},
+	})
+	require.NoError(t, err)
+
+	tokenClaims := func(names map[string]string, sources map[string]interface{}) jwtV5.MapClaims {
+		return jwtV5.MapClaims{
+			"iss":            localOIDCServerURL,
+			"aud":            audience,
+			"sub":            "user_name",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"_claim_names":   names,
+			"_claim_sources": sources,
+		}
+	}
+
+	token, err := server.SignClaims(tokenClaims(map[string]string{
+		"roles":  "src_aggregated",
+		"groups": "src_distributed",
+	}, map[string]interface{}{
+		"src_aggregated": map[string]string{"JWT": aggregated},
+		"src_distributed": map[string]string{
+			"endpoint":     localOIDCServerURL + "/oidc/claims?sub=user_name",
+			"access_token": ClaimsSourceAccessToken,
+		},
+	}))
+	require.NoError(t, err)
+
+	claims, err := auth.AuthenticateToken(token)
+	require.NoError(t, err)
+
+	roles, _ := claims.GetStrings("roles")
+	assert.Equal(t, []string{"reader"}, roles)
+	groups, _ := claims.GetStrings("groups")
+	assert.Equal(t, []string{"distributed-group-1", "distributed-group-2"}, groups)
+	_, ok := (*claims)["_claim_names"]
+	assert.False(t, ok)
+
+	// The second verification is served from the cache.
+	_, err = auth.AuthenticateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), server.claimsRequests.Load())
+
+	// ID tokens expose the resolved claims through Claims().
+	idToken, err := auth.IDTokenVerifier("").Verify(context.Background(), token)
+	require.NoError(t, err)
+	var idClaims struct {
+		Groups []string `json:"groups"`
+	}
+	assert.Nil(t, idToken.Claims(&idClaims))
+	assert.Equal(t, []string{"distributed-group-1", "distributed-group-2"}, idClaims.Groups)
+	assert.Contains(t, idToken.distributedClaims, "groups")
+
+	// Aggregated claims must be signed by the provider.
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	forged := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, jwtV5.MapClaims{
+		"iss":   localOIDCServerURL,
+		"sub":   "user_name",
+		"roles": []string{"admin"},
+	})
+	forged.Header["kid"] = kidHeader
+	forgedJWT, err := forged.SignedString(otherKey)
+	require.NoError(t, err)
+
+	token, err = server.SignClaims(tokenClaims(map[string]string{"roles": "src_aggregated"}, map[string]interface{}{
+		"src_aggregated": map[string]string{"JWT": forgedJWT},
+	}))
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrSignTokenFailed, err)
+
+	// Source claims must be issued by the provider about the same subject.
+	for name, want := range map[string]struct {
+		source jwtV5.MapClaims
+		err    error
+	}{
+		"other issuer":  {jwtV5.MapClaims{"iss": "https://other.example", "sub": "user_name", "roles": []string{"admin"}}, engine.ErrInvalidIssuer},
+		"no issuer":     {jwtV5.MapClaims{"sub": "user_name", "roles": []string{"admin"}}, engine.ErrInvalidIssuer},
+		"other subject": {jwtV5.MapClaims{"iss": localOIDCServerURL, "sub": "someone_else", "roles": []string{"admin"}}, engine.ErrInvalidSubject},
+		"no subject":    {jwtV5.MapClaims{"iss": localOIDCServerURL, "roles": []string{"admin"}}, engine.ErrInvalidSubject},
+	} {
+		sourceJWT, err := server.SignClaims(want.source)
+		require.NoError(t, err)
+		token, err = server.SignClaims(tokenClaims(map[string]string{"roles": "src_aggregated"}, map[string]interface{}{
+			"src_aggregated": map[string]string{"JWT": sourceJWT},
+		}))
+		require.NoError(t, err)
+		_, err = auth.AuthenticateToken(token)
+		assert.Equal(t, want.err, err, name)
+	}
+
+	// Distributed sources without an access token of their own are refused,
+	// without sending the caller's token to the endpoint it names.
+	token, err = server.SignClaims(tokenClaims(map[string]string{"groups": "src_distributed"}, map[string]interface{}{
+		"src_distributed": map[string]string{"endpoint": localOIDCServerURL + "/oidc/claims?sub=user_name"},
+	}))
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrInvalidClaims, err)
+	assert.Equal(t, int32(1), server.claimsRequests.Load())
+
+	// unless their host is allowed; they are then fetched without
+	// credentials.
+	token, err = server.SignClaims(tokenClaims(map[string]string{"departments": "src_distributed"}, map[string]interface{}{
+		"src_distributed": map[string]string{"endpoint": source.URL + "/claims"},
+	}))
+	require.NoError(t, err)
+	claims, err = auth.AuthenticateToken(token)
+	require.NoError(t, err)
+	departments, _ := claims.GetStrings("departments")
+	assert.Equal(t, []string{"engineering"}, departments)
+	assert.Equal(t, "", sourceAuthorization.Load())
+}
+
+func TestRelyingParty(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const clientID = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(clientID),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	srv := kratosHttp.NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	rp, err := auth.NewRelyingParty(clientID, ts.URL+"/callback", WithScopes("profile", "email"))
+	require.NoError(t, err)
+	rp.RegisterHandlers(srv, "/login", "/callback")
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{
+		Jar: jar,
+		// Stop at the page the login returns to.
+		CheckRedirect: func(req *http.Request, _ []*http.Request) error {
+			if req.URL.Host != ts.Listener.Addr().String() || req.URL.Path != "/callback" {
+				if req.URL.Path != "/oidc/authorize" {
+					return http.ErrUseLastResponse
+				}
+			}
+			return nil
+		},
+	}
+
+	res, err := client.Get(ts.URL + "/login?return_to=/profile")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/profile", res.Header.Get("Location"))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/profile", nil)
+	for _, c := range jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+	result, err := rp.LoginResult(req)
+	require.NoError(t, err)
+	assert.Equal(t, "user", result.IDToken.Subject)
+	assert.Equal(t, "/profile", result.ReturnTo)
+	assert.NotEmpty(t, result.AccessToken)
+	assert.False(t, result.Expiry.IsZero())
+
+	// A state is used once.
+	loginURL, authRequest, err := rp.StartLogin(context.Background(), "//evil.example")
+	require.NoError(t, err)
+	assert.Equal(t, "/", authRequest.ReturnTo)
+	assert.Contains(t, loginURL, "code_challenge="+pkceChallenge(authRequest.CodeVerifier))
+
+	_, err = rp.HandleCallback(context.Background(), authRequest.State, "bad-code")
+	assert.Error(t, err)
+
+	// Provider errors are not reflected to the client.
+	res, err = http.Get(ts.URL + "/callback?error=access_denied&error_description=%3Cscript%3E")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.NotContains(t, string(body), "script")
+	_, err = rp.HandleCallback(context.Background(), authRequest.State, "bad-code")
+	assert.Equal(t, ErrStateNotFound, err)
+
+	// A bad code is not reported to the client either.
+	_, authRequest, err = rp.StartLogin(context.Background(), "/")
+	require.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+"/callback?code=bad-code&state="+authRequest.State, nil)
+	req.AddCookie(&http.Cookie{Name: rp.stateCookie(authRequest.State), Value: authRequest.State})
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Contains(t, string(body), "access_denied")
+	assert.NotContains(t, string(body), "invalid_grant")
+
+	// The state must match the cookie of the browser that started the login.
+	_, authRequest, err = rp.StartLogin(context.Background(), "/")
+	require.NoError(t, err)
+	res, err = http.Get(ts.URL + "/callback?code=x&state=" + authRequest.State)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Logins started in two tabs of the same browser both complete.
+	toProvider := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	var authURLs []string
+	for _, returnTo := range []string{"/first", "/second"} {
+		res, err = toProvider.Get(ts.URL + "/login?return_to=" + returnTo)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		require.Equal(t, http.StatusFound, res.StatusCode)
+		authURLs = append(authURLs, res.Header.Get("Location"))
+	}
+	for i, returnTo := range []string{"/first", "/second"} {
+		res, err = client.Get(authURLs[i])
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, returnTo, res.Header.Get("Location"))
+	}
+
+	// A full state store refuses new logins.
+	store := NewMemoryStateStore()
+	store.maxRequests = 0
+	fullRP, err := auth.NewRelyingParty(clientID, ts.URL+"/callback", WithStateStore(store))
+	require.NoError(t, err)
+	srv.Route("/").GET("/login-full", fullRP.LoginHandler())
+	res, err = toProvider.Get(ts.URL + "/login-full")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	// Codes are only redeemed with the verifier of the request.
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	authURL, err := rp.AuthCodeURL(&AuthRequest{State: "s", Nonce: "n", CodeVerifier: "v"})
+	require.NoError(t, err)
+	res, err = noRedirect.Get(authURL)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	location, err := res.Location()
+	require.NoError(t, err)
+	_, err = rp.exchange(context.Background(), location.Query().Get("code"), "other")
+	assert.Error(t, err)
+}
+
+func TestMemoryStateStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := NewMemoryStateStore()
+	store.maxRequests = 2
+	store.now = func() time.Time { return now }
+
+	assert.Nil(t, store.SaveAuthRequest(ctx, &AuthRequest{State: "a", ExpiresAt: now.Add(time.Minute)}))
+	assert.Nil(t, store.SaveAuthRequest(ctx, &AuthRequest{State: "b", ExpiresAt: now.Add(2 * time.Minute)}))
+	assert.Equal(t, ErrTooManyAuthRequests, store.SaveAuthRequest(ctx, &AuthRequest{State: "c", ExpiresAt: now.Add(time.Minute)}))
+
+	// Completed requests free their slot,
+	_, err := store.TakeAuthRequest(ctx, "a")
+	assert.Nil(t, err)
+	assert.Nil(t, store.SaveAuthRequest(ctx, &AuthRequest{State: "c", ExpiresAt: now.Add(time.Minute)}))
+
+	// and so do expired ones.
+	now = now.Add(90 * time.Second)
+	assert.Nil(t, store.SaveAuthRequest(ctx, &AuthRequest{State: "d", ExpiresAt: now.Add(time.Minute)}))
+	_, err = store.TakeAuthRequest(ctx, "c")
+	assert.Equal(t, ErrStateNotFound, err)
+	_, err = store.TakeAuthRequest(ctx, "b")
+	assert.Nil(t, err)
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	for returnTo, want := range map[string]string{
+		"/profile":             "/profile",
+		"/profile?tab=1#top":   "/profile?tab=1#top",
+		"":                     "/",
+		"profile":              "/",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+		"/\t/evil.example":     "/",
+		"/\n/evil.example":     "/",
+		"/\r//evil.example":    "/",
+		"/a\\b":                "/",
+		"/%09/evil.example":    "/%09/evil.example",
+	} {
+		assert.Equal(t, want, safeReturnTo(returnTo), returnTo)
+	}
+
+	// Decoded query parameters are checked, not their encoding.
+	query, err := url.ParseQuery("return_to=%2F%09%2Fevil.com")
+	require.NoError(t, err)
+	assert.Equal(t, "/", safeReturnTo(query.Get("return_to")))
+}
+
+func TestAuthenticator_LazyDiscovery(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8085"
+	const audience = "kratos.dev"
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithLazyDiscovery(true),
+		WithDiscoveryRetry(10*time.Millisecond, 50*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	_, err = auth.AuthenticateToken("x.y.z")
+	assert.Equal(t, engine.ErrProviderUnavailable, err)
+	_, err = auth.IDTokenVerifier("").Verify(context.Background(), "x.y.z")
+	assert.Equal(t, engine.ErrProviderUnavailable, err)
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	select {
+	case <-auth.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("provider was not discovered")
+	}
+
+	token, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	claims, err := auth.AuthenticateToken(token)
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user_name", sub)
+}
+
+func TestAuthenticator_DiscoveryValidation(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// The discovery document must be about the configured issuer.
+	server.discoveryIssuer.Store("http://evil.example")
+	_, err = newAuthenticator(WithIssuerURL(localOIDCServerURL), WithAudience(audience))
+	assert.Error(t, err)
+	server.discoveryIssuer.Store("")
+
+	// A provider without keys is not available,
+	server.jwksEmpty.Store(true)
+	_, err = newAuthenticator(WithIssuerURL(localOIDCServerURL), WithAudience(audience))
+	assert.Error(t, err)
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithLazyDiscovery(true),
+		WithDiscoveryRetry(10*time.Millisecond, 50*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	requests := server.jwksRequests.Load()
+	require.Eventually(t, func() bool {
+		return server.jwksRequests.Load() >= requests+2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	token, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	_, err = auth.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrProviderUnavailable, err)
+
+	// and discovery is retried until it serves keys.
+	server.jwksEmpty.Store(false)
+	select {
+	case <-auth.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("provider was not discovered")
+	}
+	_, err = auth.AuthenticateToken(token)
+	assert.NoError(t, err)
+}
+
+func TestAuthenticator_DiscoveryCache(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	cachePath := filepath.Join(t.TempDir(), "oidc.json")
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithDiscoveryCache(cachePath),
+	)
+	require.NoError(t, err)
+	auth.Close()
+	assert.FileExists(t, cachePath)
+
+	token, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	require.NoError(t, server.Close())
+
+	// Warm start with the provider down.
+	auth, err = newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithDiscoveryCache(cachePath),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	assert.Nil(t, auth.checkReady())
+	claims, err := auth.AuthenticateToken(token)
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user_name", sub)
+
+	// The cache of another issuer is ignored.
+	other, err := newAuthenticator(
+		WithIssuerURL("http://localhost:8085"),
+		WithAudience(audience),
+		WithDiscoveryCache(cachePath),
+		WithLazyDiscovery(true),
+	)
+	require.NoError(t, err)
+	defer other.Close()
+
+	_, err = other.AuthenticateToken(token)
+	assert.Equal(t, engine.ErrProviderUnavailable, err)
+}
+
+func TestAuthenticator_JWKSRefresh(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithJWKSUnknownKIDRefresh(time.Hour),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	status := auth.JWKSStatus()
+	assert.Equal(t, localOIDCServerURL+"/oidc/jwks", status.URL)
+	assert.Nil(t, status.LastError)
+	assert.False(t, status.LastRefresh.IsZero())
+	assert.Equal(t, 1, status.Keys)
+
+	// An unknown kid refreshes the keys once per interval.
+	unknown := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, jwtV5.MapClaims{"iss": localOIDCServerURL, "aud": audience})
+	unknown.Header["kid"] = "rotated"
+	unknownToken, err := unknown.SignedString(server.privateKey)
+	require.NoError(t, err)
+
+	requests := server.jwksRequests.Load()
+	_, err = auth.AuthenticateToken(unknownToken)
+	assert.Error(t, err)
+	assert.Equal(t, requests+1, server.jwksRequests.Load())
+	_, err = auth.AuthenticateToken(unknownToken)
+	assert.Error(t, err)
+	assert.Equal(t, requests+1, server.jwksRequests.Load())
+
+	// Periodic refreshes stop on Close.
+	periodicOptions := []Option{
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithJWKSRefreshInterval(20 * time.Millisecond),
+		WithJWKSRequestTimeout(100 * time.Millisecond),
+	}
+	periodic, err := newAuthenticator(periodicOptions...)
+	require.NoError(t, err)
+
+	requests = server.jwksRequests.Load()
+	assert.Eventually(t, func() bool {
+		return server.jwksRequests.Load() > requests
+	}, time.Second, 10*time.Millisecond)
+
+	periodic.Close()
+	requests = server.jwksRequests.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, requests, server.jwksRequests.Load())
+
+	// Failed refreshes are reported and keep the last keys.
+	failing, err := newAuthenticator(periodicOptions...)
+	require.NoError(t, err)
+	defer failing.Close()
+
+	require.NoError(t, server.Close())
+	assert.Eventually(t, func() bool {
+		return failing.JWKSStatus().LastError != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	status = failing.JWKSStatus()
+	assert.False(t, status.LastRefresh.IsZero())
+	assert.Equal(t, 1, status.Keys)
+}
+
+type recordingTransport struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.paths = append(t.paths, req.URL.Path)
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAuthenticator_HTTPClient(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// The client is used for discovery, JWKS and UserInfo alike.
+	transport := &recordingTransport{}
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithHTTPClient(&http.Client{Transport: transport}),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	token, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	_, err = auth.GetUserInfo(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/.well-known/openid-configuration", "/oidc/jwks", "/oidc/userinfo"}, transport.paths)
+
+	_, err = newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithRootCAs(x509.NewCertPool()),
+	)
+	assert.Error(t, err)
+
+	// Root CAs.
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer tlsServer.Close()
+
+	o := &Options{log: log.NewHelper(log.DefaultLogger)}
+	client, err := o.newHTTPClient()
+	require.NoError(t, err)
+	_, err = client.Get(tlsServer.URL)
+	assert.Error(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsServer.Certificate())
+	o.rootCAs = pool
+	client, err = o.newHTTPClient()
+	require.NoError(t, err)
+	res, err := client.Get(tlsServer.URL)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	// Retry policy.
+	var calls atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer flaky.Close()
+
+	o = &Options{log: log.NewHelper(log.DefaultLogger)}
+	require.NoError(t, WithRetryPolicy(1, time.Millisecond, time.Millisecond)(o))
+	client, err = o.newHTTPClient()
+	require.NoError(t, err)
+	res, err = client.Get(flaky.URL)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+
+	require.NoError(t, WithRetryPolicy(0, 0, 0)(o))
+	client, err = o.newHTTPClient()
+	require.NoError(t, err)
+	calls.Store(0)
+	res, err = client.Get(flaky.URL)
+	assert.Error(t, err)
+	if res != nil {
+		_ = res.Body.Close()
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestNewAuthenticatorFromConfig(t *testing.T) {
+	const issuer = "https://idp.internal.example"
+	const audience = "kratos.dev"
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwksJSON := []byte(fmt.Sprintf(`{"keys":[{"kid":"static","kty":"RSA","alg":"RS256","use":"sig","n":%q,"e":"AQAB"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes())))
+
+	config := ProviderConfig{
+		Issuer:  issuer,
+		JWKSURL: issuer + "/jwks",
+	}
+
+	// No request is made: the issuer is not reachable.
+	auth, err := NewAuthenticatorFromConfig(config, jwksJSON, WithAudience(audience))
+	require.NoError(t, err)
+	defer auth.Close()
+
+	sign := func(iss string) string {
+		token := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, jwtV5.MapClaims{
+			"iss": iss,
+			"aud": audience,
+			"sub": "user_name",
+		})
+		token.Header["kid"] = "static"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	claims, err := auth.AuthenticateToken(sign(issuer))
+	require.NoError(t, err)
+	sub, _ := claims.GetSubject()
+	assert.Equal(t, "user_name", sub)
+
+	_, err = auth.AuthenticateToken(sign("https://other.example"))
+	assert.Equal(t, engine.ErrInvalidIssuer, err)
+
+	cfg, err := auth.(Configurator).GetConfiguration()
+	require.NoError(t, err)
+	assert.Equal(t, config.JWKSURL, cfg.JWKSURL)
+	assert.Equal(t, 1, auth.(*Authenticator).JWKSStatus().Keys)
+
+	// The issuer URL must match the configuration.
+	_, err = NewAuthenticatorFromConfig(config, jwksJSON, WithIssuerURL("https://other.example"))
+	assert.Error(t, err)
+
+	_, err = NewAuthenticatorFromConfig(ProviderConfig{Issuer: issuer}, nil)
+	assert.Error(t, err)
+
+	_, err = NewAuthenticatorFromConfig(config, []byte(`{"keys":[]}`))
+	assert.Error(t, err)
+
+	_, err = NewAuthenticator(WithIssuerURL(issuer), WithJWKS(jwksJSON))
+	assert.Error(t, err)
+
+	// Without static keys, they are fetched from the configured jwks_uri.
+	const localOIDCServerURL = "http://localhost:8083"
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	remote, err := newAuthenticator(WithProviderConfig(ProviderConfig{
+		Issuer:  localOIDCServerURL,
+		JWKSURL: localOIDCServerURL + "/oidc/jwks",
+	}), WithAudience(audience))
+	require.NoError(t, err)
+	defer remote.Close()
+
+	token, err := server.GetToken(audience, "user_name")
+	require.NoError(t, err)
+	_, err = remote.AuthenticateToken(token)
+	require.NoError(t, err)
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const clientID = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	auth, err := newAuthenticator(WithIssuerURL(localOIDCServerURL))
+	require.NoError(t, err)
+	defer auth.Close()
+
+	var mu sync.Mutex
+	var ended []string
+	failHook := false
+	logout, err := auth.NewBackChannelLogout(clientID, func(_ context.Context, token *LogoutToken) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failHook {
+			return errors.New("session store unavailable")
+		}
+		ended = append(ended, token.SessionID)
+		return nil
+	})
+	require.NoError(t, err)
+
+	srv := kratosHttp.NewServer()
+	logout.RegisterHandler(srv, "/backchannel-logout")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	logoutClaims := func(jti string) jwtV5.MapClaims {
+		return jwtV5.MapClaims{
+			"iss":    localOIDCServerURL,
+			"aud":    clientID,
+			"iat":    time.Now().Unix(),
+			"jti":    jti,
+			"sid":    "session-1",
+			"events": map[string]interface{}{BackChannelLogoutEvent: map[string]interface{}{}},
+		}
+	}
+	post := func(claims jwtV5.MapClaims) int {
+		token, err := server.SignClaims(claims)
+		require.NoError(t, err)
+		res, err := http.PostForm(ts.URL+"/backchannel-logout", url.Values{"logout_token": {token}})
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post(logoutClaims("jti-1")))
+	assert.Equal(t, []string{"session-1"}, ended)
+
+	// Replays are rejected.
+	assert.Equal(t, http.StatusBadRequest, post(logoutClaims("jti-1")))
+	assert.Len(t, ended, 1)
+
+	// A failed logout may be retried.
+	setFailHook := func(fail bool) {
+		mu.Lock()
+		failHook = fail
+		mu.Unlock()
+	}
+	setFailHook(true)
+	assert.Equal(t, http.StatusBadRequest, post(logoutClaims("jti-2")))
+	setFailHook(false)
+	assert.Equal(t, http.StatusOK, post(logoutClaims("jti-2")))
+	assert.Len(t, ended, 2)
+
+	invalid := map[string]func(jwtV5.MapClaims){
+		"no events":      func(c jwtV5.MapClaims) { delete(c, "events") },
+		"other event":    func(c jwtV5.MapClaims) { c["events"] = map[string]interface{}{"other": map[string]interface{}{}} },
+		"nonce":          func(c jwtV5.MapClaims) { c["nonce"] = "n" },
+		"no sid or sub":  func(c jwtV5.MapClaims) { delete(c, "sid") },
+		"no jti":         func(c jwtV5.MapClaims) { delete(c, "jti") },
+		"no iat":         func(c jwtV5.MapClaims) { delete(c, "iat") },
+		"stale":          func(c jwtV5.MapClaims) { c["iat"] = time.Now().Add(-time.Hour).Unix() },
+		"other audience": func(c jwtV5.MapClaims) { c["aud"] = "other" },
+		"other issuer":   func(c jwtV5.MapClaims) { c["iss"] = "http://localhost:8084" },
+	}
+	for name, mutate := range invalid {
+		claims := logoutClaims("jti-" + name)
+		mutate(claims)
+		assert.Equal(t, http.StatusBadRequest, post(claims), name)
+	}
+	assert.Len(t, ended, 2)
+
+	// The "typ" header is a media type, compared case-insensitively.
+	postTyped := func(claims jwtV5.MapClaims, typ string) int {
+		token := jwtV5.NewWithClaims(jwtV5.SigningMethodRS256, claims)
+		token.Header["kid"] = kidHeader
+		token.Header["typ"] = typ
+		signed, err := token.SignedString(server.privateKey)
+		require.NoError(t, err)
+		res, err := http.PostForm(ts.URL+"/backchannel-logout", url.Values{"logout_token": {signed}})
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	for _, typ := range []string{"logout+jwt", "Logout+JWT", "application/logout+jwt", "APPLICATION/LOGOUT+JWT", "jwt"} {
+		assert.Equal(t, http.StatusOK, postTyped(logoutClaims("jti-typ-"+typ), typ), typ)
+	}
+	for _, typ := range []string{"at+jwt", "application/at+jwt", "application/", "logout"} {
+		assert.Equal(t, http.StatusBadRequest, postTyped(logoutClaims("jti-typ-"+typ), typ), typ)
+	}
+	assert.Len(t, ended, 7)
+
+	// A subject alone ends all of its sessions.
+	claims := logoutClaims("jti-sub")
+	delete(claims, "sid")
+	claims["sub"] = "user"
+	token, err := server.SignClaims(claims)
+	require.NoError(t, err)
+	logoutToken, err := logout.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "user", logoutToken.Subject)
+	assert.Empty(t, logoutToken.SessionID)
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	_, err = newAuthenticator(WithIssuerURL(localOIDCServerURL), WithClientCredentials("service", ""))
+	assert.Error(t, err)
+	_, err = newAuthenticator(WithIssuerURL(localOIDCServerURL),
+		WithClientCredentials("service", "secret", WithTokenResource("not a uri")))
+	assert.Error(t, err)
+
+	auth, err := newAuthenticator(WithIssuerURL(localOIDCServerURL),
+		WithClientCredentials("service", "secret",
+			WithTokenScopes("read", "write"),
+			WithTokenAudience("https://api.kratos.dev"),
+		),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	// The service token is never returned as an identity minted from claims.
+	token, err := auth.CreateIdentity(engine.AuthClaims{engine.ClaimFieldSubject: "user"})
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, int32(0), server.tokenRequests.Load())
+
+	source := auth.ClientCredentials()
+	require.NotNil(t, source)
+
+	// Concurrent callers share one token request.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := source.Token(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), server.tokenRequests.Load())
+
+	client := &myTransporter{reqHeader: headerCarrier{}, replyHeader: headerCarrier{}}
+	ctx := transport.NewClientContext(context.Background(), client)
+	_, err = auth.CreateIdentityWithContext(ctx, engine.ContextTypeKratosMetaData, engine.AuthClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), server.tokenRequests.Load())
+
+	accessToken, ok := strings.CutPrefix(client.reqHeader.Get("Authorization"), engine.BearerWord+" ")
+	require.True(t, ok)
+
+	claims := jwtV5.MapClaims{}
+	_, err = jwtV5.ParseWithClaims(accessToken, claims, auth.JWKs.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, "service", claims["sub"])
+	assert.Equal(t, "read write", claims["scope"])
+	assert.Equal(t, []interface{}{"https://api.kratos.dev"}, claims["aud"])
+
+	// Tokens are replaced shortly before they expire.
+	cached, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cached.Expiry, time.Minute)
+
+	// Meanwhile, the cached token is still returned without waiting, even
+	// while the token endpoint is slow.
+	release := make(chan struct{})
+	server.tokenRelease.Store(release)
+
+	source.now = func() time.Time { return cached.Expiry.Add(-30 * time.Second) }
+	for i := 0; i < 3; i++ {
+		token, err := source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, cached, token)
+	}
+	require.Eventually(t, func() bool { return server.tokenRequests.Load() == 2 }, 5*time.Second, time.Millisecond)
+
+	// Callers without a usable token wait, but only as long as their ctx.
+	source.Invalidate()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = source.Token(ctx)
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	server.tokenRelease.Store((chan struct{})(nil))
+
+	// The replacement is cached once it arrives.
+	require.Eventually(t, func() bool {
+		token, err := source.Token(context.Background())
+		return err == nil && token != cached
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), server.tokenRequests.Load())
+	source.now = time.Now
+
+	source.Invalidate()
+	_, err = source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), server.tokenRequests.Load())
+
+	// A token of unknown lifetime is cached for the default lifetime.
+	server.tokenNoExpiry.Store(true)
+	noExpiry, err := auth.NewClientCredentialsTokenSource("service", "secret", WithTokenDefaultLifetime(10*time.Minute))
+	require.NoError(t, err)
+	unknown, err := noExpiry.Token(context.Background())
+	require.NoError(t, err)
+	assert.True(t, unknown.Expiry.IsZero())
+	_, err = noExpiry.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), server.tokenRequests.Load())
+
+	noExpiry.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	_, err = noExpiry.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), server.tokenRequests.Load())
+
+	_, err = auth.NewClientCredentialsTokenSource("service", "secret", WithTokenDefaultLifetime(0))
+	assert.Error(t, err)
+}
+
+func TestAuthenticator_Revoke(t *testing.T) {
+	const localOIDCServerURL = "http://localhost:8083"
+	const audience = "kratos.dev"
+
+	server, err := NewMockOidcServer(localOIDCServerURL)
+	require.NoError(t, err)
+	defer server.Close()
+
+	plain, err := newAuthenticator(WithIssuerURL(localOIDCServerURL))
+	require.NoError(t, err)
+	defer plain.Close()
+	assert.Error(t, plain.Revoke(context.Background(), "token", ""))
+
+	auth, err := newAuthenticator(
+		WithIssuerURL(localOIDCServerURL),
+		WithAudience(audience),
+		WithUserInfoEnrichment(time.Minute),
+		WithClientCredentials("service", "secret"),
+	)
+	require.NoError(t, err)
+	defer auth.Close()
+
+	accessToken, err := server.SignClaims(jwtV5.MapClaims{
+		"iss": localOIDCServerURL,
+		"aud": audience,
+		"sub": "user_name",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	_, err = auth.AuthenticateToken(accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), server.userInfoRequests.Load())
+
+	// Revoking drops the cached UserInfo claims, so the next verification
+	// asks the provider again.
+	require.NoError(t, auth.Revoke(context.Background(), accessToken, TokenTypeHintAccessToken))
+	hint, ok := server.revoked.Load(accessToken)
+	require.True(t, ok)
+	assert.Equal(t, TokenTypeHintAccessToken, hint)
+
+	_, err = auth.AuthenticateToken(accessToken)
+	assert.Equal(t, engine.ErrUnauthenticated, err)
+	assert.Equal(t, int32(2), server.userInfoRequests.Load())
+
+	// A revoked token of the service itself is not used again.
+	source := auth.ClientCredentials()
+	serviceToken, err := source.Token(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, auth.Revoke(context.Background(), serviceToken.AccessToken, ""))
+	_, err = source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), server.tokenRequests.Load())
+
+	// A relying party revokes with its own credentials.
+	rp, err := auth.NewRelyingParty(audience, "http://localhost/callback")
+	require.NoError(t, err)
+	require.NoError(t, rp.Revoke(context.Background(), "refresh-token", TokenTypeHintRefreshToken))
+	_, ok = server.revoked.Load("refresh-token")
+	assert.True(t, ok)
+
+	// Providers without a revocation endpoint cannot revoke.
+	static, err := newAuthenticator(
+		WithProviderConfig(ProviderConfig{JWKSURL: localOIDCServerURL + "/oidc/jwks"}),
+		WithIssuerURL(localOIDCServerURL),
+		WithClientCredentials("service", "secret"),
+	)
+	require.NoError(t, err)
+	defer static.Close()
+	assert.Error(t, static.Revoke(context.Background(), accessToken, ""))
+}
@@ -1,35 +1,436 @@
 package oidc
 
 import (
-	jwtV5 "github.com/golang-jwt/jwt/v5"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+
+	"github.com/tx7do/kratos-authn/engine"
 )
 
 type Options struct {
 	IssuerURL string
 	Audience  string
 
-	signingMethod jwtV5.SigningMethod
+	// algorithms are the signing algorithms accepted when verifying tokens.
+	// Defaults to the provider's id_token_signing_alg_values_supported,
+	// filtered by SupportedAlgorithms.
+	algorithms []string
+
+	// userInfoEnrichment merges UserInfo claims into verified claims,
+	// caching them per token for userInfoCacheTTL.
+	userInfoEnrichment bool
+	userInfoCacheTTL   time.Duration
+
+	// resolveClaimSources resolves aggregated and distributed claims, and
+	// claimSourceHosts are the hosts distributed sources without an access
+	// token may be fetched from.
+	resolveClaimSources bool
+	claimSourceHosts    map[string]bool
+
+	// claimMapping copies claims to other names, keyed by source claim.
+	claimMapping map[string]string
+
+	// lazyDiscovery discovers the provider in the background instead of
+	// failing construction when it is unreachable.
+	lazyDiscovery     bool
+	discoveryRetryMin time.Duration
+	discoveryRetryMax time.Duration
+
+	// discoveryCachePath is a file caching the discovery document and keys.
+	discoveryCachePath string
+
+	// jwksRefreshInterval is how often the provider keys are refreshed, and
+	// jwksUnknownKIDRefreshInterval the minimum time between refreshes for
+	// unknown key IDs (0 disables them).
+	jwksRefreshInterval           time.Duration
+	jwksUnknownKIDRefreshInterval time.Duration
+	jwksRequestTimeout            time.Duration
+	jwksHTTPClient                *http.Client
+
+	// httpClient and rootCAs configure the client for all provider
+	// requests, which are retried according to the retry policy.
+	httpClient   *http.Client
+	rootCAs      *x509.CertPool
+	retryMax     int
+	retryWaitMin time.Duration
+	retryWaitMax time.Duration
+
+	log *log.Helper
+
+	// providerConfig, when set, replaces discovery, and jwks the key set of
+	// the provider.
+	providerConfig *ProviderConfig
+	jwks           json.RawMessage
+
+	// clientID and clientSecret authenticate the service itself, e.g. for
+	// the tokens of CreateIdentityWithContext.
+	clientID           string
+	clientSecret       string
+	clientTokenOptions []ClientCredentialsOption
 }
 
-type Option func(d *Options)
+type Option func(o *Options) error
 
 // WithIssuerURL set issuer url
-func WithIssuerURL(url string) Option {
-	return func(o *Options) {
-		o.IssuerURL = url
+func WithIssuerURL(issuerURL string) Option {
+	return func(o *Options) error {
+		u, err := url.Parse(issuerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid issuer URL %q", issuerURL)
+		}
+		o.IssuerURL = issuerURL
+		return nil
 	}
 }
 
 // WithAudience set audience
 func WithAudience(audience string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		o.Audience = audience
+		return nil
 	}
 }
 
-// WithSigningMethod set signing method
+// WithSigningMethod accepts only tokens signed with alg,
+// the same as WithAllowedAlgorithms(alg).
 func WithSigningMethod(alg string) Option {
-	return func(o *Options) {
-		o.signingMethod = jwtV5.GetSigningMethod(alg)
+	return WithAllowedAlgorithms(alg)
+}
+
+// WithAllowedAlgorithms sets the signing algorithms accepted when verifying
+// tokens, overriding the list advertised by the provider. Every algorithm
+// must be in SupportedAlgorithms.
+func WithAllowedAlgorithms(algs ...string) Option {
+	return func(o *Options) error {
+		for _, alg := range algs {
+			if !SupportedAlgorithms[alg] {
+				return fmt.Errorf("%w: %q", engine.ErrUnsupportedSigningMethod, alg)
+			}
+		}
+		o.algorithms = append(o.algorithms, algs...)
+		return nil
+	}
+}
+
+// WithUserInfoEnrichment fetches the UserInfo of every verified access token
+// and merges its claims (e.g. email, name, groups) into the returned claims;
+// claims in the token take precedence. The UserInfo "sub" must match the
+// token "sub". Responses are cached per token for ttl, but never beyond the
+// token's expiry; a ttl of 0 uses DefaultUserInfoCacheTTL. While UserInfo is
+// unavailable, stale cached claims or the token claims alone are returned.
+func WithUserInfoEnrichment(ttl time.Duration) Option {
+	return func(o *Options) error {
+		if ttl < 0 {
+			return errors.New("userinfo cache ttl must not be negative")
+		}
+		if ttl == 0 {
+			ttl = DefaultUserInfoCacheTTL
+		}
+		o.userInfoEnrichment = true
+		o.userInfoCacheTTL = ttl
+		return nil
+	}
+}
+
+// WithResolveClaimSources resolves aggregated and distributed claims
+// ("_claim_names" / "_claim_sources") of verified tokens and merges them into
+// the returned claims. See Authenticator.ResolveClaims.
+func WithResolveClaimSources(enable bool) Option {
+	return func(o *Options) error {
+		o.resolveClaimSources = enable
+		return nil
+	}
+}
+
+// WithClaimSourceHosts allows fetching distributed claims without
+// credentials from endpoints on the given hosts (host or host:port), e.g.
+// "graph.microsoft.com". Distributed sources that carry no access token of
+// their own are refused on any other host.
+func WithClaimSourceHosts(hosts ...string) Option {
+	return func(o *Options) error {
+		if o.claimSourceHosts == nil {
+			o.claimSourceHosts = make(map[string]bool, len(hosts))
+		}
+		for _, host := range hosts {
+			if host == "" || strings.ContainsAny(host, "/?#@") {
+				return fmt.Errorf("invalid claim source host %q", host)
+			}
+			o.claimSourceHosts[strings.ToLower(host)] = true
+		}
+		return nil
+	}
+}
+
+// WithClaimMapping copies claims to the names the application expects,
+// e.g. {"oid": "user_id"} for Entra ID. mapping is keyed by the provider's
+// claim name; the source claim is kept.
+func WithClaimMapping(mapping map[string]string) Option {
+	return func(o *Options) error {
+		if o.claimMapping == nil {
+			o.claimMapping = make(map[string]string, len(mapping))
+		}
+		for from, to := range mapping {
+			if from == "" || to == "" {
+				return errors.New("claim mapping names must not be empty")
+			}
+			o.claimMapping[from] = to
+		}
+		return nil
+	}
+}
+
+// WithLazyDiscovery returns the authenticator right away and discovers the
+// provider in the background, retrying with backoff until it succeeds.
+// Until then, requests fail with engine.ErrProviderUnavailable; see
+// Authenticator.Ready.
+func WithLazyDiscovery(enable bool) Option {
+	return func(o *Options) error {
+		o.lazyDiscovery = enable
+		return nil
+	}
+}
+
+// WithDiscoveryRetry sets the backoff of background discovery: the first
+// retry waits minDelay, and every further one twice as long, up to maxDelay.
+func WithDiscoveryRetry(minDelay, maxDelay time.Duration) Option {
+	return func(o *Options) error {
+		if minDelay <= 0 || maxDelay < minDelay {
+			return errors.New("discovery retry delays must be positive and min must not exceed max")
+		}
+		o.discoveryRetryMin = minDelay
+		o.discoveryRetryMax = maxDelay
+		return nil
+	}
+}
+
+// WithDiscoveryCache saves the discovery document and key set to path after
+// every successful discovery, and warm-starts from it: a valid cache of the
+// same issuer makes the authenticator ready without reaching the provider,
+// which is then discovered in the background to refresh the keys. The
+// cached discovery document is used until restart.
+func WithDiscoveryCache(path string) Option {
+	return func(o *Options) error {
+		if path == "" {
+			return errors.New("discovery cache path must not be empty")
+		}
+		o.discoveryCachePath = path
+		return nil
+	}
+}
+
+// WithJWKSRefreshInterval sets how often the provider keys are refreshed.
+func WithJWKSRefreshInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return errors.New("jwks refresh interval must be positive")
+		}
+		o.jwksRefreshInterval = interval
+		return nil
+	}
+}
+
+// WithJWKSUnknownKIDRefresh sets the minimum time between key refreshes
+// triggered by tokens signed with an unknown key, e.g. right after the
+// provider rotated its keys. Within it, such tokens are rejected without a
+// request. 0 disables these refreshes.
+func WithJWKSUnknownKIDRefresh(minInterval time.Duration) Option {
+	return func(o *Options) error {
+		if minInterval < 0 {
+			return errors.New("jwks unknown kid refresh interval must not be negative")
+		}
+		o.jwksUnknownKIDRefreshInterval = minInterval
+		return nil
+	}
+}
+
+// WithJWKSRequestTimeout bounds every key set request.
+func WithJWKSRequestTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return errors.New("jwks request timeout must be positive")
+		}
+		o.jwksRequestTimeout = timeout
+		return nil
+	}
+}
+
+// WithJWKSHTTPClient sets the HTTP client for key set requests, used as is
+// instead of the client configured with WithHTTPClient.
+func WithJWKSHTTPClient(client *http.Client) Option {
+	return func(o *Options) error {
+		if client == nil {
+			return errors.New("jwks http client must not be nil")
+		}
+		o.jwksHTTPClient = client
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client for discovery, JWKS, UserInfo and
+// token requests, e.g. one that uses an egress proxy. Requests are still
+// retried according to WithRetryPolicy. Defaults to a pooled client that
+// honours the HTTP_PROXY and HTTPS_PROXY environment variables.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *Options) error {
+		if client == nil {
+			return errors.New("http client must not be nil")
+		}
+		o.httpClient = client
+		return nil
+	}
+}
+
+// WithRootCAs trusts only the given CAs for provider requests, e.g. for an
+// identity provider with a private CA. The transport of a client set with
+// WithHTTPClient must be an *http.Transport; it is cloned, not modified.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(o *Options) error {
+		if pool == nil {
+			return errors.New("root CA pool must not be nil")
+		}
+		o.rootCAs = pool
+		return nil
+	}
+}
+
+// WithRetryPolicy retries failed provider requests (connection errors, 429
+// and 5xx responses) up to maxRetries times, waiting from minWait, doubling
+// up to maxWait, or as long as a Retry-After header asks. 0 disables
+// retries.
+func WithRetryPolicy(maxRetries int, minWait, maxWait time.Duration) Option {
+	return func(o *Options) error {
+		if maxRetries < 0 {
+			return errors.New("max retries must not be negative")
+		}
+		if minWait < 0 || maxWait < minWait {
+			return errors.New("retry waits must not be negative and min must not exceed max")
+		}
+		o.retryMax = maxRetries
+		o.retryWaitMin = minWait
+		o.retryWaitMax = maxWait
+		return nil
+	}
+}
+
+// WithLogger sets the logger for background discovery, key refreshes and
+// retried requests.
+func WithLogger(logger log.Logger) Option {
+	return func(o *Options) error {
+		o.log = log.NewHelper(log.With(logger, "module", "authn.oidc"))
+		return nil
+	}
+}
+
+// WithProviderConfig skips discovery and uses config instead. The issuer
+// URL defaults to config.Issuer; if both are set, they must match. Keys are
+// fetched from config.JWKSURL unless set with WithJWKS.
+func WithProviderConfig(config ProviderConfig) Option {
+	return func(o *Options) error {
+		o.providerConfig = &config
+		return nil
+	}
+}
+
+// WithJWKS sets the key set of the provider as JWK Set JSON, so that no
+// request is made to fetch keys. It requires WithProviderConfig.
+func WithJWKS(jwksJSON []byte) Option {
+	return func(o *Options) error {
+		if jwksKeyCount(jwksJSON) == 0 {
+			return errors.New("jwks must be a JWK Set with at least one key")
+		}
+		o.jwks = append(json.RawMessage(nil), jwksJSON...)
+		return nil
+	}
+}
+
+// WithClientCredentials makes CreateIdentityWithContext attach access tokens
+// obtained with the client credentials grant, so that outbound calls
+// authenticate as clientID.
+func WithClientCredentials(clientID, clientSecret string, opts ...ClientCredentialsOption) Option {
+	return func(o *Options) error {
+		if clientID == "" || clientSecret == "" {
+			return errors.New("client ID and secret are required")
+		}
+		o.clientID = clientID
+		o.clientSecret = clientSecret
+		o.clientTokenOptions = opts
+		return nil
+	}
+}
+
+// mapClaims applies the claim mapping to verified claims. Sources are read
+// before any target is written, so mappings do not chain.
+func (o *Options) mapClaims(claims engine.AuthClaims) {
+	if len(o.claimMapping) == 0 {
+		return
+	}
+	mapped := make(map[string]interface{}, len(o.claimMapping))
+	for from, to := range o.claimMapping {
+		if v, ok := claims[from]; ok {
+			mapped[to] = v
+		}
+	}
+	for k, v := range mapped {
+		claims[k] = v
+	}
+}
+
+// isClaimSourceHostAllowed reports whether endpoint is on a host allowed
+// with WithClaimSourceHosts.
+func (o *Options) isClaimSourceHostAllowed(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return o.claimSourceHosts[strings.ToLower(u.Host)]
+}
+
+// validate checks that the configuration is complete.
+func (o *Options) validate() error {
+	if o.providerConfig != nil {
+		if err := o.validateProviderConfig(); err != nil {
+			return err
+		}
+	} else if o.jwks != nil {
+		return errors.New("static jwks require a provider configuration")
+	}
+
+	if o.IssuerURL == "" {
+		return errors.New("issuer URL is required")
+	}
+	return nil
+}
+
+// validateProviderConfig checks the static provider configuration and
+// defaults the issuer URL to its issuer.
+func (o *Options) validateProviderConfig() error {
+	config := o.providerConfig
+
+	if config.Issuer == "" {
+		config.Issuer = o.IssuerURL
+	}
+	if o.IssuerURL == "" {
+		if err := WithIssuerURL(config.Issuer)(o); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != strings.TrimSuffix(o.IssuerURL, "/") {
+		return fmt.Errorf("provider issuer %q does not match issuer URL %q", config.Issuer, o.IssuerURL)
+	}
+
+	if o.jwks == nil && config.JWKSURL == "" {
+		return errors.New("provider configuration needs a jwks_uri or static jwks")
+	}
+	if o.discoveryCachePath != "" {
+		return errors.New("discovery cache cannot be used with a provider configuration")
 	}
+	return nil
 }
@@ -0,0 +1,591 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	kratosErrors "github.com/go-kratos/kratos/v2/errors"
+	kratosHttp "github.com/go-kratos/kratos/v2/transport/http"
+)
+
+const (
+	// DefaultAuthRequestTTL is how long a login may take between /login and
+	// /callback.
+	DefaultAuthRequestTTL = 10 * time.Minute
+
+	// DefaultSessionTTL is how long a login result is kept when the token
+	// response carries no expiry.
+	DefaultSessionTTL = time.Hour
+
+	// DefaultMaxAuthRequests is the number of pending authorization
+	// requests a MemoryStateStore keeps at most.
+	DefaultMaxAuthRequests = 10000
+
+	DefaultStateCookieName   = "oidc_state"
+	DefaultSessionCookieName = "oidc_session"
+)
+
+var (
+	// ErrStateNotFound is returned by a StateStore for unknown or expired
+	// entries.
+	ErrStateNotFound = errors.New("oidc: state not found")
+
+	// ErrTooManyAuthRequests is returned by a StateStore that cannot keep
+	// more pending authorization requests.
+	ErrTooManyAuthRequests = errors.New("oidc: too many pending authorization requests")
+)
+
+// AuthRequest is a pending authorization request, kept between the redirect
+// to the provider and the callback.
+type AuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	// ReturnTo is the local path to redirect to after the login.
+	ReturnTo  string
+	ExpiresAt time.Time
+}
+
+// LoginResult is the outcome of a completed login.
+type LoginResult struct {
+	IDToken      *IDToken
+	RawIDToken   string
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	// Expiry is when the access token expires; zero if unknown.
+	Expiry time.Time
+	// ReturnTo is the local path the login was started from.
+	ReturnTo string
+}
+
+// StateStore keeps pending authorization requests and login results.
+// Implementations must be safe for concurrent use.
+type StateStore interface {
+	// SaveAuthRequest stores a pending request under its state. It returns
+	// ErrTooManyAuthRequests if the store is full.
+	SaveAuthRequest(ctx context.Context, req *AuthRequest) error
+	// TakeAuthRequest returns and removes the pending request for state, so
+	// that every state is used once. It returns ErrStateNotFound for unknown
+	// or expired states.
+	TakeAuthRequest(ctx context.Context, state string) (*AuthRequest, error)
+
+	// SaveLoginResult stores the result of a login under the session ID.
+	SaveLoginResult(ctx context.Context, sessionID string, result *LoginResult) error
+	// LoginResult returns the login result of the session, or
+	// ErrStateNotFound.
+	LoginResult(ctx context.Context, sessionID string) (*LoginResult, error)
+}
+
+// RelyingParty logs users in with the Authorization Code flow and PKCE.
+//
+// See: https://openid.net/specs/openid-connect-core-1_0.html#CodeFlowAuth
+// See: https://www.rfc-editor.org/rfc/rfc7636
+type RelyingParty struct {
+	provider *Authenticator
+
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	store             StateStore
+	authRequestTTL    time.Duration
+	stateCookieName   string
+	sessionCookieName string
+	secureCookies     bool
+}
+
+// RelyingPartyOption configures a RelyingParty.
+type RelyingPartyOption func(rp *RelyingParty) error
+
+// WithClientSecret authenticates to the token endpoint with
+// client_secret_basic. Without it, the client is public and relies on PKCE.
+func WithClientSecret(secret string) RelyingPartyOption {
+	return func(rp *RelyingParty) error {
+		rp.clientSecret = secret
+		return nil
+	}
+}
+
+// WithScopes sets the requested scopes. "openid" is always requested.
+func WithScopes(scopes ...string) RelyingPartyOption {
+	return func(rp *RelyingParty) error {
+		rp.scopes = append(rp.scopes, scopes...)
+		return nil
+	}
+}
+
+// WithStateStore sets the store for pending requests and login results.
+// Defaults to an in-memory store, which only suits a single instance.
+func WithStateStore(store StateStore) RelyingPartyOption {
+	return func(rp *RelyingParty) error {
+		if store == nil {
+			return errors.New("state store must not be nil")
+		}
+		rp.store = store
+		return nil
+	}
+}
+
+// WithAuthRequestTTL sets how long a login may take.
+func WithAuthRequestTTL(ttl time.Duration) RelyingPartyOption {
+	return func(rp *RelyingParty) error {
+		if ttl <= 0 {
+			return errors.New("auth request ttl must be positive")
+		}
+		rp.authRequestTTL = ttl
+		return nil
+	}
+}
+
+// WithCookieNames sets the names of the state and session cookies. Every
+// login has its own state cookie, named state followed by "_" and a hash of
+// its state, so that logins in several tabs do not overwrite each other.
+func WithCookieNames(state, session string) RelyingPartyOption {
+	return func(rp *RelyingParty) error {
+		if state == "" || session == "" || state == session {
+			return errors.New("cookie names must be distinct and not empty")
+		}
+		rp.stateCookieName = state
+		rp.sessionCookieName = session
+		return nil
+	}
+}
+
+// WithSecureCookies marks the cookies Secure. Cookies are always Secure when
+// the redirect URL is https.
+func WithSecureCookies(secure bool) RelyingPartyOption {
+	return func(rp *RelyingParty) error {
+		rp.secureCookies = secure
+		return nil
+	}
+}
+
+// NewRelyingParty creates a relying party for the provider. redirectURL is
+// the absolute URL of the callback handler registered with the provider.
+func (a *Authenticator) NewRelyingParty(clientID, redirectURL string, opts ...RelyingPartyOption) (*RelyingParty, error) {
+	if clientID == "" {
+		return nil, errors.New("client ID is required")
+	}
+	u, err := url.Parse(redirectURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid redirect URL %q", redirectURL)
+	}
+	rp := &RelyingParty{
+		provider:          a,
+		clientID:          clientID,
+		redirectURL:       redirectURL,
+		authRequestTTL:    DefaultAuthRequestTTL,
+		stateCookieName:   DefaultStateCookieName,
+		sessionCookieName: DefaultSessionCookieName,
+		secureCookies:     u.Scheme == "https",
+	}
+
+	for _, o := range opts {
+		if err = o(rp); err != nil {
+			return nil, err
+		}
+	}
+
+	if rp.store == nil {
+		rp.store = NewMemoryStateStore()
+	}
+
+	return rp, nil
+}
+
+// providerConfig returns the discovery document once the provider is
+// available.
+func (rp *RelyingParty) providerConfig() (*ProviderConfig, error) {
+	if err := rp.provider.checkReady(); err != nil {
+		return nil, err
+	}
+	config := rp.provider.providerConfig
+	if config.AuthURL == "" || config.TokenURL == "" {
+		return nil, errors.New("provider has no authorization or token endpoint")
+	}
+	return config, nil
+}
+
+// AuthCodeURL returns the provider URL that starts the login for the
+// request.
+func (rp *RelyingParty) AuthCodeURL(req *AuthRequest) (string, error) {
+	config, err := rp.providerConfig()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := []string{"openid"}
+	for _, s := range rp.scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {rp.clientID},
+		"redirect_uri":          {rp.redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {pkceChallenge(req.CodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	if strings.Contains(config.AuthURL, "?") {
+		return config.AuthURL + "&" + v.Encode(), nil
+	}
+	return config.AuthURL + "?" + v.Encode(), nil
+}
+
+// StartLogin creates and stores a new authorization request and returns the
+// provider URL to redirect the user to. returnTo is the local path to come
+// back to after the login.
+func (rp *RelyingParty) StartLogin(ctx context.Context, returnTo string) (string, *AuthRequest, error) {
+	if _, err := rp.providerConfig(); err != nil {
+		return "", nil, err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	req := &AuthRequest{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ReturnTo:     safeReturnTo(returnTo),
+		ExpiresAt:    time.Now().Add(rp.authRequestTTL),
+	}
+	authURL, err := rp.AuthCodeURL(req)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = rp.store.SaveAuthRequest(ctx, req); err != nil {
+		return "", nil, err
+	}
+
+	return authURL, req, nil
+}
+
+// HandleCallback completes the login: it consumes the pending request of
+// state, exchanges the code with the PKCE verifier and verifies the ID token
+// including its nonce and, if present, its access token hash.
+func (rp *RelyingParty) HandleCallback(ctx context.Context, state, code string) (*LoginResult, error) {
+	if state == "" || code == "" {
+		return nil, errors.New("missing state or code")
+	}
+
+	req, err := rp.store.TakeAuthRequest(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(req.ExpiresAt) {
+		return nil, ErrStateNotFound
+	}
+
+	tokens, err := rp.exchange(ctx, code, req.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := rp.provider.IDTokenVerifier(rp.clientID).VerifyWithNonce(ctx, tokens.IDToken, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.AccessTokenHash != "" {
+		if err = idToken.VerifyAccessToken(tokens.AccessToken); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &LoginResult{
+		IDToken:      idToken,
+		RawIDToken:   tokens.IDToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+		ReturnTo:     req.ReturnTo,
+	}
+	if tokens.ExpiresIn > 0 {
+		result.Expiry = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	}
+	return result, nil
+}
+
+// exchange redeems the authorization code at the token endpoint.
+func (rp *RelyingParty) exchange(ctx context.Context, code, verifier string) (*tokenResponse, error) {
+	if _, err := rp.providerConfig(); err != nil {
+		return nil, err
+	}
+
+	return rp.provider.requestToken(ctx, rp.clientID, rp.clientSecret, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.redirectURL},
+		"code_verifier": {verifier},
+	})
+}
+
+// LoginResult returns the login result of the session cookie of the request.
+func (rp *RelyingParty) LoginResult(r *http.Request) (*LoginResult, error) {
+	cookie, err := r.Cookie(rp.sessionCookieName)
+	if err != nil {
+		return nil, ErrStateNotFound
+	}
+	return rp.store.LoginResult(r.Context(), cookie.Value)
+}
+
+// RegisterHandlers registers LoginHandler and CallbackHandler on the server
+// under the given paths, e.g. "/login" and "/callback".
+func (rp *RelyingParty) RegisterHandlers(srv *kratosHttp.Server, loginPath, callbackPath string) {
+	r := srv.Route("/")
+	r.GET(loginPath, rp.LoginHandler())
+	r.GET(callbackPath, rp.CallbackHandler())
+}
+
+// LoginHandler starts a login and redirects to the provider. The optional
+// "return_to" query parameter is the local path to come back to. It answers
+// 503 while the state store is full.
+func (rp *RelyingParty) LoginHandler() kratosHttp.HandlerFunc {
+	return func(ctx kratosHttp.Context) error {
+		authURL, req, err := rp.StartLogin(ctx, ctx.Query().Get("return_to"))
+		if errors.Is(err, ErrTooManyAuthRequests) {
+			rp.provider.options.log.Warnf("starting OIDC login failed: %s", err.Error())
+			return kratosErrors.ServiceUnavailable("LOGIN_FAILED", "temporarily_unavailable")
+		}
+		if err != nil {
+			rp.provider.options.log.Errorf("starting OIDC login failed: %s", err.Error())
+			return kratosErrors.InternalServer("LOGIN_FAILED", "server_error")
+		}
+
+		// Bind the state to this browser, so a callback cannot be replayed
+		// in another one (login CSRF).
+		http.SetCookie(ctx.Response(), rp.cookie(rp.stateCookie(req.State), req.State, req.ExpiresAt))
+		http.Redirect(ctx.Response(), ctx.Request(), authURL, http.StatusFound)
+		return nil
+	}
+}
+
+// CallbackHandler completes a login, stores the result under a new session
+// ID, sets the session cookie and redirects to the path the login was started
+// from.
+func (rp *RelyingParty) CallbackHandler() kratosHttp.HandlerFunc {
+	return func(ctx kratosHttp.Context) error {
+		query := ctx.Query()
+		if e := query.Get("error"); e != "" {
+			rp.provider.options.log.Warnf("OIDC login failed at the provider: %s: %s", e, query.Get("error_description"))
+			return kratosErrors.Unauthorized("LOGIN_FAILED", "access_denied")
+		}
+
+		state := query.Get("state")
+		if state == "" {
+			return kratosErrors.BadRequest("INVALID_STATE", "state does not match")
+		}
+		cookie, err := ctx.Request().Cookie(rp.stateCookie(state))
+		if err != nil || cookie.Value != state {
+			return kratosErrors.BadRequest("INVALID_STATE", "state does not match")
+		}
+
+		result, err := rp.HandleCallback(ctx, state, query.Get("code"))
+		if err != nil {
+			rp.provider.options.log.Warnf("completing OIDC login failed: %s", err.Error())
+			return kratosErrors.Unauthorized("LOGIN_FAILED", "access_denied")
+		}
+
+		sessionID, err := randomString()
+		if err == nil {
+			err = rp.store.SaveLoginResult(ctx, sessionID, result)
+		}
+		if err != nil {
+			rp.provider.options.log.Errorf("saving OIDC login result failed: %s", err.Error())
+			return kratosErrors.InternalServer("LOGIN_FAILED", "server_error")
+		}
+
+		expiresAt := result.Expiry
+		if expiresAt.IsZero() {
+			expiresAt = time.Now().Add(DefaultSessionTTL)
+		}
+
+		http.SetCookie(ctx.Response(), rp.cookie(rp.stateCookie(state), "", time.Unix(0, 0)))
+		http.SetCookie(ctx.Response(), rp.cookie(rp.sessionCookieName, sessionID, expiresAt))
+		http.Redirect(ctx.Response(), ctx.Request(), result.ReturnTo, http.StatusFound)
+		return nil
+	}
+}
+
+// stateCookie returns the name of the state cookie of a login.
+func (rp *RelyingParty) stateCookie(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return rp.stateCookieName + "_" + base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func (rp *RelyingParty) cookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   rp.secureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// safeReturnTo only allows local paths, so the login cannot be used as an
+// open redirect. Backslashes and control characters are rejected anywhere:
+// browsers treat a backslash like "/" and drop tabs and newlines, which
+// would turn e.g. "/\t/evil.example" into "//evil.example".
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		return "/"
+	}
+	for _, c := range returnTo {
+		if c == '\\' || unicode.IsControl(c) {
+			return "/"
+		}
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
+	return returnTo
+}
+
+// randomString returns 256 random bits, base64url-encoded. It is used for
+// states, nonces, PKCE verifiers and session IDs.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge returns the S256 code challenge of the verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// MemoryStateStore is an in-memory StateStore for a single instance. It
+// keeps at most DefaultMaxAuthRequests pending authorization requests, so
+// that unauthenticated hits on the login handler cannot grow it without
+// bound; beyond that, SaveAuthRequest fails until requests complete or
+// expire.
+type MemoryStateStore struct {
+	mu          sync.Mutex
+	requests    map[string]*AuthRequest
+	results     map[string]memoryLoginResult
+	maxRequests int
+
+	now func() time.Time
+}
+
+type memoryLoginResult struct {
+	result    *LoginResult
+	expiresAt time.Time
+}
+
+var _ StateStore = (*MemoryStateStore)(nil)
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		requests:    make(map[string]*AuthRequest),
+		results:     make(map[string]memoryLoginResult),
+		maxRequests: DefaultMaxAuthRequests,
+		now:         time.Now,
+	}
+}
+
+func (s *MemoryStateStore) SaveAuthRequest(_ context.Context, req *AuthRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge()
+	if len(s.requests) >= s.maxRequests {
+		return ErrTooManyAuthRequests
+	}
+	s.requests[req.State] = req
+	return nil
+}
+
+func (s *MemoryStateStore) TakeAuthRequest(_ context.Context, state string) (*AuthRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[state]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	delete(s.requests, state)
+
+	if !s.now().Before(req.ExpiresAt) {
+		return nil, ErrStateNotFound
+	}
+	return req, nil
+}
+
+func (s *MemoryStateStore) SaveLoginResult(_ context.Context, sessionID string, result *LoginResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge()
+
+	expiresAt := result.Expiry
+	if expiresAt.IsZero() {
+		expiresAt = s.now().Add(DefaultSessionTTL)
+	}
+	s.results[sessionID] = memoryLoginResult{result: result, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryStateStore) LoginResult(_ context.Context, sessionID string) (*LoginResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.results[sessionID]
+	if !ok || !s.now().Before(r.expiresAt) {
+		return nil, ErrStateNotFound
+	}
+	return r.result, nil
+}
+
+// purge drops expired entries. The caller must hold s.mu.
+func (s *MemoryStateStore) purge() {
+	now := s.now()
+	for k, req := range s.requests {
+		if !now.Before(req.ExpiresAt) {
+			delete(s.requests, k)
+		}
+	}
+	for k, r := range s.results {
+		if !now.Before(r.expiresAt) {
+			delete(s.results, k)
+		}
+	}
+}
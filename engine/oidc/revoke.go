@@ -0,0 +1,70 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/url"
+)
+
+const (
+	// TokenTypeHintAccessToken hints that a token to revoke is an access
+	// token.
+	TokenTypeHintAccessToken = "access_token"
+	// TokenTypeHintRefreshToken hints that a token to revoke is a refresh
+	// token.
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// Revoke revokes a token issued to the client set with WithClientCredentials
+// at the revocation endpoint of the provider, and drops whatever is cached
+// for it. hint, if not empty, is the token_type_hint.
+//
+// See: https://datatracker.ietf.org/doc/html/rfc7009
+func (a *Authenticator) Revoke(ctx context.Context, token, hint string) error {
+	if a.options.clientID == "" {
+		return errors.New("revocation requires client credentials")
+	}
+	return a.revoke(ctx, a.options.clientID, a.options.clientSecret, token, hint)
+}
+
+// Revoke revokes a token issued to the relying party, e.g. the refresh
+// token of a user who logs out.
+func (rp *RelyingParty) Revoke(ctx context.Context, token, hint string) error {
+	return rp.provider.revoke(ctx, rp.clientID, rp.clientSecret, token, hint)
+}
+
+func (a *Authenticator) revoke(ctx context.Context, clientID, clientSecret, token, hint string) error {
+	if token == "" {
+		return errors.New("token is required")
+	}
+
+	// The token is forgotten locally even if the provider cannot be reached.
+	key := sha256.Sum256([]byte(token))
+	if a.userInfoCache != nil {
+		a.userInfoCache.remove(key)
+	}
+	if a.claimSourceCache != nil {
+		a.claimSourceCache.remove(key)
+	}
+	if a.tokenSource != nil {
+		a.tokenSource.forget(token)
+	}
+
+	if err := a.checkReady(); err != nil {
+		return err
+	}
+	if a.providerConfig.RevocationURL == "" {
+		return errors.New("provider has no revocation endpoint")
+	}
+
+	form := url.Values{"token": {token}}
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+
+	// The provider answers 200 for tokens that are invalid or already
+	// revoked, so only errors of the request itself are reported.
+	_, err := a.postClientForm(ctx, a.providerConfig.RevocationURL, clientID, clientSecret, form)
+	return err
+}
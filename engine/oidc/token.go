@@ -0,0 +1,95 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxTokenResponseSize bounds the size of a token endpoint response.
+const maxTokenResponseSize = 1 << 20
+
+// tokenResponse is the successful response of the token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// tokenErrorResponse is the error response of the token endpoint.
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestToken posts a token request to the token endpoint of the provider.
+func (a *Authenticator) requestToken(ctx context.Context, clientID, clientSecret string, form url.Values) (*tokenResponse, error) {
+	if err := a.checkReady(); err != nil {
+		return nil, err
+	}
+	if a.providerConfig.TokenURL == "" {
+		return nil, errors.New("provider has no token endpoint")
+	}
+
+	body, err := a.postClientForm(ctx, a.providerConfig.TokenURL, clientID, clientSecret, form)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &tokenResponse{}
+	if err = json.Unmarshal(body, tokens); err != nil {
+		return nil, fmt.Errorf("failed parsing token response: %w", err)
+	}
+	if tokens.AccessToken == "" {
+		return nil, errors.New("token response has no access token")
+	}
+	return tokens, nil
+}
+
+// postClientForm posts form to an endpoint of the provider and returns the
+// body of a 200 response. The client authenticates with client_secret_basic,
+// or, without a secret, as a public client by its client_id.
+func (a *Authenticator) postClientForm(ctx context.Context, endpoint, clientID, clientSecret string, form url.Values) ([]byte, error) {
+	if clientSecret == "" {
+		form.Set("client_id", clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error forming request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error posting to %s: %w", endpoint, err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxTokenResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var e tokenErrorResponse
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s returned %s: %s", endpoint, e.Error, e.ErrorDescription)
+		}
+		return nil, fmt.Errorf("unexpected status code from %s: %v", endpoint, res.StatusCode)
+	}
+	return body, nil
+}
@@ -1,7 +1,8 @@
 package oidc
 
-// ProviderConfig allows creating providers when discovery isn't supported. It's
-// generally easier to use NewProvider directly.
+// ProviderConfig allows creating providers when discovery isn't supported,
+// see NewAuthenticatorFromConfig. It's generally easier to use
+// NewAuthenticator directly.
 // See https://datatracker.ietf.org/doc/html/rfc8414#section-2
 type ProviderConfig struct {
 	// IssuerURL is the identity of the provider, and the string it uses to sign
@@ -51,7 +52,12 @@ var SupportedAlgorithms = map[string]bool{
 	PS512: true,
 }
 
+// claimSource is an entry of "_claim_sources": either a distributed source
+// (endpoint and optional access token) or an aggregated one (a signed JWT).
+//
+// See: https://openid.net/specs/openid-connect-core-1_0.html#AggregatedDistributedClaims
 type claimSource struct {
 	Endpoint    string `json:"endpoint"`
 	AccessToken string `json:"access_token"`
+	JWT         string `json:"JWT"`
 }
@@ -1,13 +1,48 @@
 package oidc
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
 )
 
+// DefaultUserInfoCacheTTL is how long UserInfo claims are cached per token
+// when no ttl is configured.
+const DefaultUserInfoCacheTTL = 5 * time.Minute
+
+// maxUserInfoSize bounds the size of a UserInfo response.
+const maxUserInfoSize = 1 << 20
+
+// errUserInfoRejected is returned by GetUserInfo when the provider rejects
+// the access token.
+var errUserInfoRejected = errors.New("userinfo rejected the access token")
+
+// userInfoEnvelopeClaims are the claims of a signed UserInfo response that
+// describe the response itself rather than the user, and so are not merged
+// into the claims of a token.
+var userInfoEnvelopeClaims = []string{
+	engine.ClaimFieldIssuer,
+	engine.ClaimFieldAudience,
+	engine.ClaimFieldExpirationTime,
+	engine.ClaimFieldIssuedAt,
+	engine.ClaimFieldNotBefore,
+	engine.ClaimFieldJwtID,
+}
+
 // UserInfo represents the OpenID Connect userinfo claims.
 type UserInfo struct {
 	Subject       string `json:"sub"`
+	Name          string `json:"name"`
 	Profile       string `json:"profile"`
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
@@ -22,3 +57,160 @@ func (u *UserInfo) Claims(v interface{}) error {
 	}
 	return json.Unmarshal(u.claims, v)
 }
+
+// GetUserInfo fetches the claims about the end-user the access token was
+// issued for from the provider's UserInfo endpoint. Signed (JWT) responses
+// are verified with the provider keys.
+//
+// See: https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func (a *Authenticator) GetUserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	if err := a.checkReady(); err != nil {
+		return nil, err
+	}
+	if a.providerConfig.UserInfoURL == "" {
+		return nil, errors.New("provider has no userinfo endpoint")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.providerConfig.UserInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error forming userinfo request: %w", err)
+	}
+	req.Header.Set("Authorization", engine.BearerWord+" "+accessToken)
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting userinfo: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: status code %v", errUserInfoRejected, res.StatusCode)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code getting userinfo: %v", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxUserInfoSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading userinfo response: %w", err)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType == "application/jwt" {
+		if body, err = a.verifyUserInfoJWT(string(body)); err != nil {
+			return nil, err
+		}
+	}
+
+	userInfo := &UserInfo{}
+	if err = json.Unmarshal(body, userInfo); err != nil {
+		return nil, fmt.Errorf("failed parsing userinfo: %w", err)
+	}
+	if userInfo.Subject == "" {
+		return nil, errors.New("userinfo is missing sub")
+	}
+	userInfo.claims = body
+
+	return userInfo, nil
+}
+
+// verifyUserInfoJWT verifies a signed UserInfo response and returns its
+// payload. As OpenID Connect requires for signed responses, "iss" must be
+// the provider and "aud" must contain the client ID, which is the audience
+// set with WithAudience.
+func (a *Authenticator) verifyUserInfoJWT(token string) ([]byte, error) {
+	alg, err := tokenAlgorithm(token)
+	if err != nil {
+		return nil, err
+	}
+	if !a.isAllowedAlgorithm(alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+
+	claims := jwtV5.MapClaims{}
+	if _, err = jwtV5.ParseWithClaims(token, claims, a.JWKs.Keyfunc, jwtV5.WithValidMethods(a.algorithms)); err != nil {
+		return nil, mapParseError(err)
+	}
+
+	if iss, _ := claims.GetIssuer(); iss != a.options.IssuerURL {
+		return nil, engine.ErrInvalidIssuer
+	}
+	aud, _ := claims.GetAudience()
+	found := false
+	for _, v := range aud {
+		if v != "" && v == a.options.Audience {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, engine.ErrInvalidAudience
+	}
+
+	return tokenPayload(token)
+}
+
+// enrichClaims merges the UserInfo claims of the access token into claims.
+// Claims already in the token are kept. The token is already verified, so
+// if UserInfo cannot be fetched, the claims cached for it are used even if
+// stale, or else the token claims alone. Only a provider that rejects the
+// token (e.g. once revoked), a UserInfo response about another subject or
+// the end of ctx fail the authentication.
+func (a *Authenticator) enrichClaims(ctx context.Context, accessToken string, claims engine.AuthClaims) error {
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return engine.ErrInvalidSubject
+	}
+
+	key := sha256.Sum256([]byte(accessToken))
+
+	info, ok := a.userInfoCache.get(key)
+	if !ok {
+		if info, err = a.userInfoClaims(ctx, accessToken); err == nil {
+			expiresAt := time.Time{}
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				expiresAt = exp.Time
+			}
+			a.userInfoCache.put(key, info, expiresAt)
+		} else if errors.Is(err, errUserInfoRejected) || ctx.Err() != nil {
+			return engine.ErrUnauthenticated
+		} else if info, ok = a.userInfoCache.getStale(key); ok {
+			a.options.log.Warnf("fetching userinfo failed, using cached claims: %s", err.Error())
+		} else {
+			a.options.log.Warnf("fetching userinfo failed, using token claims only: %s", err.Error())
+			return nil
+		}
+	}
+
+	// The UserInfo sub must match the token, otherwise the response may
+	// belong to another user.
+	if infoSub, _ := info["sub"].(string); infoSub != sub {
+		return engine.ErrInvalidSubject
+	}
+
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return nil
+}
+
+// userInfoClaims returns the member claims of the UserInfo of the access
+// token.
+func (a *Authenticator) userInfoClaims(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	userInfo, err := a.GetUserInfo(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var info map[string]interface{}
+	if err = userInfo.Claims(&info); err != nil {
+		return nil, err
+	}
+	for _, k := range userInfoEnvelopeClaims {
+		delete(info, k)
+	}
+	return info, nil
+}
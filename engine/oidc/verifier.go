@@ -0,0 +1,198 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	jwtV5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// IDTokenVerifier verifies raw ID tokens issued by the provider of an
+// Authenticator.
+//
+// See: https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+type IDTokenVerifier struct {
+	auth     *Authenticator
+	clientID string
+}
+
+// ErrMissingClientID is returned when verifying ID tokens without a client
+// ID, as the "aud" claim must then contain it.
+var ErrMissingClientID = errors.New("oidc: ID token verification requires a client ID")
+
+// IDTokenVerifier returns a verifier for ID tokens issued to clientID.
+// An empty clientID falls back to the configured audience; without either,
+// verification fails with ErrMissingClientID.
+func (a *Authenticator) IDTokenVerifier(clientID string) *IDTokenVerifier {
+	if clientID == "" {
+		clientID = a.options.Audience
+	}
+	return &IDTokenVerifier{auth: a, clientID: clientID}
+}
+
+// Verify checks the signature, issuer, audience, expiry and, for tokens with
+// several audiences, the authorized party of the raw ID token.
+func (v *IDTokenVerifier) Verify(ctx context.Context, rawIDToken string) (*IDToken, error) {
+	return v.VerifyWithNonce(ctx, rawIDToken, "")
+}
+
+// VerifyWithNonce is like Verify, and also checks that the token carries the
+// nonce sent in the authentication request, unless nonce is empty.
+func (v *IDTokenVerifier) VerifyWithNonce(ctx context.Context, rawIDToken, nonce string) (*IDToken, error) {
+	a := v.auth
+	if v.clientID == "" {
+		return nil, ErrMissingClientID
+	}
+	if err := a.checkReady(); err != nil {
+		return nil, err
+	}
+
+	alg, err := tokenAlgorithm(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if !a.isAllowedAlgorithm(alg) {
+		return nil, engine.ErrUnsupportedSigningMethod
+	}
+
+	claims := jwtV5.MapClaims{}
+	if _, err = jwtV5.ParseWithClaims(rawIDToken, claims, a.JWKs.Keyfunc,
+		jwtV5.WithValidMethods(a.algorithms),
+		jwtV5.WithIssuer(a.options.IssuerURL),
+		jwtV5.WithAudience(v.clientID),
+		jwtV5.WithExpirationRequired(),
+	); err != nil {
+		return nil, mapParseError(err)
+	}
+
+	authClaims := engine.AuthClaims(claims)
+
+	aud, err := authClaims.GetAudience()
+	if err != nil {
+		return nil, engine.ErrInvalidAudience
+	}
+
+	// "azp" names the client the token was issued to when there are
+	// several audiences; if present it must be this client.
+	azp, err := authClaims.GetString("azp")
+	if err != nil {
+		return nil, engine.ErrInvalidAudience
+	}
+	if (len(aud) > 1 || azp != "") && azp != v.clientID {
+		return nil, engine.ErrInvalidAudience
+	}
+
+	tokenNonce, err := authClaims.GetString("nonce")
+	if err != nil || (nonce != "" && tokenNonce != nonce) {
+		return nil, engine.ErrInvalidNonce
+	}
+
+	payload, err := tokenPayload(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken := &IDToken{
+		Audience:     aud,
+		Nonce:        tokenNonce,
+		sigAlgorithm: alg,
+		claims:       payload,
+	}
+	if idToken.Issuer, err = authClaims.GetIssuer(); err != nil {
+		return nil, engine.ErrInvalidIssuer
+	}
+	if idToken.Subject, err = authClaims.GetSubject(); err != nil {
+		return nil, engine.ErrInvalidSubject
+	}
+	if idToken.AccessTokenHash, err = authClaims.GetString("at_hash"); err != nil {
+		return nil, engine.ErrInvalidAtHash
+	}
+	if exp, err := authClaims.GetExpirationTime(); err == nil && exp != nil {
+		idToken.Expiry = exp.Time
+	}
+	if iat, err := authClaims.GetIssuedAt(); err == nil && iat != nil {
+		idToken.IssuedAt = iat.Time
+	} else if err != nil {
+		return nil, engine.ErrInvalidIssuedAt
+	}
+	if idToken.distributedClaims, err = parseDistributedClaims(payload); err != nil {
+		return nil, err
+	}
+
+	if a.options.resolveClaimSources {
+		if _, ok := authClaims[claimFieldClaimNames]; ok {
+			if err = a.resolveClaims(ctx, rawIDToken, authClaims); err != nil {
+				return nil, err
+			}
+			if idToken.claims, err = json.Marshal(authClaims); err != nil {
+				return nil, engine.ErrInvalidClaims
+			}
+		}
+	}
+
+	return idToken, nil
+}
+
+// mapParseError maps a jwt parse or validation error to an engine error.
+func mapParseError(err error) error {
+	switch {
+	case errors.Is(err, jwtV5.ErrTokenMalformed):
+		return engine.ErrInvalidToken
+	case errors.Is(err, jwtV5.ErrTokenSignatureInvalid):
+		return engine.ErrSignTokenFailed
+	case errors.Is(err, jwtV5.ErrTokenExpired) || errors.Is(err, jwtV5.ErrTokenNotValidYet):
+		return engine.ErrTokenExpired
+	case errors.Is(err, jwtV5.ErrTokenRequiredClaimMissing):
+		return engine.ErrInvalidExpiration
+	case errors.Is(err, jwtV5.ErrTokenInvalidAudience):
+		return engine.ErrInvalidAudience
+	case errors.Is(err, jwtV5.ErrTokenInvalidIssuer):
+		return engine.ErrInvalidIssuer
+	default:
+		return engine.ErrInvalidToken
+	}
+}
+
+// tokenPayload returns the decoded payload of a compact JWS.
+func tokenPayload(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, engine.ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, engine.ErrInvalidToken
+	}
+	return payload, nil
+}
+
+// parseDistributedClaims maps each distributed claim name to its source.
+// Aggregated claims (sources carrying a JWT) are not included.
+//
+// See: https://openid.net/specs/openid-connect-core-1_0.html#AggregatedDistributedClaims
+func parseDistributedClaims(payload []byte) (map[string]claimSource, error) {
+	var raw claimSources
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, engine.ErrInvalidClaims
+	}
+	if len(raw.ClaimNames) == 0 {
+		return nil, nil
+	}
+
+	distributed := make(map[string]claimSource)
+	for name, sourceName := range raw.ClaimNames {
+		source, ok := raw.ClaimSources[sourceName]
+		if !ok {
+			return nil, engine.ErrInvalidClaims
+		}
+		if source.Endpoint != "" {
+			distributed[name] = source
+		}
+	}
+	return distributed, nil
+}
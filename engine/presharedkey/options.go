@@ -1,21 +1,27 @@
 package presharedkey
 
+import "errors"
+
 type KeySet map[string]bool
 
 type Options struct {
 	ValidKeys KeySet
 }
 
-type Option func(d *Options)
+type Option func(o *Options) error
 
 // WithKeys set key set
 func WithKeys(validKeys []string) Option {
-	return func(o *Options) {
+	return func(o *Options) error {
 		vKeys := make(KeySet)
 		for _, k := range validKeys {
+			if k == "" {
+				return errors.New("pre-shared key must not be empty")
+			}
 			vKeys[k] = true
 		}
 
 		o.ValidKeys = vKeys
+		return nil
 	}
 }
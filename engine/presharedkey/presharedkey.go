@@ -15,16 +15,18 @@ type Authenticator struct {
 var _ engine.Authenticator = (*Authenticator)(nil)
 
 func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
-	//if len(validKeys) < 1 {
-	//	return nil, errors.New("invalid auth configuration, please specify at least one key")
-	//}
-
 	auth := &Authenticator{
 		options: &Options{},
 	}
 
 	for _, o := range opts {
-		o(auth.options)
+		if err := o(auth.options); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(auth.options.ValidKeys) < 1 {
+		return nil, errors.New("invalid auth configuration, please specify at least one key")
 	}
 
 	return auth, nil
@@ -40,10 +42,6 @@ func (pka *Authenticator) Authenticate(ctx context.Context, contextType engine.C
 }
 
 func (pka *Authenticator) AuthenticateToken(token string) (*engine.AuthClaims, error) {
-	if len(pka.options.ValidKeys) < 1 {
-		return nil, errors.New("invalid auth configuration, please specify at least one key")
-	}
-
 	if _, found := pka.options.ValidKeys[token]; found {
 		return &engine.AuthClaims{}, nil
 	}
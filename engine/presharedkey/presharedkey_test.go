@@ -91,3 +91,11 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 	assert.Equal(t, "", sub)
 	fmt.Println(authToken)
 }
+
+func TestNewAuthenticator_NoKeys(t *testing.T) {
+	_, err := NewAuthenticator()
+	assert.NotNil(t, err)
+
+	_, err = NewAuthenticator(WithKeys([]string{""}))
+	assert.NotNil(t, err)
+}
@@ -1,5 +1,7 @@
 package session
 
+import "errors"
+
 // SessionStore is the interface for storing and retrieving session data
 // by session ID. Implementations can use memory, Redis, a database, etc.
 type SessionStore interface {
@@ -25,16 +27,25 @@ type Options struct {
 	sessionIDHeader string
 }
 
-type Option func(o *Options)
+type Option func(o *Options) error
 
 // WithStore sets the session store implementation.
 func WithStore(s SessionStore) Option {
-	return func(o *Options) { o.store = s }
+	return func(o *Options) error {
+		if s == nil {
+			return errors.New("session store must not be nil")
+		}
+		o.store = s
+		return nil
+	}
 }
 
 // WithSessionIDHeader overrides the metadata key for the session ID.
 func WithSessionIDHeader(name string) Option {
-	return func(o *Options) { o.sessionIDHeader = name }
+	return func(o *Options) error {
+		o.sessionIDHeader = name
+		return nil
+	}
 }
 
 func (o *Options) getStore() SessionStore {
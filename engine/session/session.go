@@ -124,7 +124,9 @@ var _ engine.Authenticator = (*Authenticator)(nil)
 func NewAuthenticator(opts ...Option) (engine.Authenticator, error) {
 	o := &Options{}
 	for _, opt := range opts {
-		opt(o)
+		if err := opt(o); err != nil {
+			return nil, err
+		}
 	}
 	return &Authenticator{options: o}, nil
 }
@@ -33,6 +33,11 @@ func TestNewAuthenticator_WithSessionIDHeader(t *testing.T) {
 	require.NotNil(t, auth)
 }
 
+func TestNewAuthenticator_NilStore(t *testing.T) {
+	_, err := NewAuthenticator(WithStore(nil))
+	assert.NotNil(t, err)
+}
+
 // ---------------------------------------------------------------------------
 // MemoryStore
 // ---------------------------------------------------------------------------
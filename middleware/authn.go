@@ -28,6 +28,8 @@ func Server(authenticator engine.Authenticator, opts ...Option) middleware.Middl
 
 			ctx = engine.ContextWithAuthClaims(ctx, claims)
 
+			o.renewIdentity(ctx, authenticator, claims)
+
 			return handler(ctx, req)
 		}
 	}
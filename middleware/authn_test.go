@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -15,6 +17,7 @@ import (
 
 	"github.com/tx7do/kratos-authn/engine"
 	"github.com/tx7do/kratos-authn/engine/jwt"
+	"github.com/tx7do/kratos-authn/engine/oidc"
 )
 
 type headerCarrier http.Header
@@ -49,10 +52,11 @@ func newTokenHeader(headerKey string, token string) *headerCarrier {
 }
 
 type Transport struct {
-	kind      transport.Kind
-	endpoint  string
-	operation string
-	reqHeader transport.Header
+	kind        transport.Kind
+	endpoint    string
+	operation   string
+	reqHeader   transport.Header
+	replyHeader transport.Header
 }
 
 func (tr *Transport) Kind() transport.Kind {
@@ -72,12 +76,13 @@ func (tr *Transport) RequestHeader() transport.Header {
 }
 
 func (tr *Transport) ReplyHeader() transport.Header {
-	return nil
+	return tr.replyHeader
 }
 
 func generateJwtKey(key, sub string) string {
 	mapClaims := jwtV5.MapClaims{}
 	mapClaims["sub"] = sub
+	mapClaims["exp"] = time.Now().Add(time.Hour).Unix()
 	claims := jwtV5.NewWithClaims(jwtV5.SigningMethodHS256, mapClaims)
 	token, _ := claims.SignedString([]byte(key))
 	return token
@@ -121,7 +126,7 @@ func TestServer(t *testing.T) {
 		{
 			name:      "method invalid",
 			ctx:       transport.NewServerContext(context.Background(), &Transport{reqHeader: newTokenHeader(engine.HeaderAuthorize, token)}),
-			alg:       "ES384",
+			alg:       "HS384",
 			exceptErr: engine.ErrUnsupportedSigningMethod,
 			key:       testKey,
 		},
@@ -184,6 +189,7 @@ func TestClient(t *testing.T) {
 			authenticator, err := jwt.NewAuthenticator(
 				jwt.WithKey([]byte(testKey)),
 				jwt.WithSigningMethod("HS256"),
+				jwt.WithTTL(time.Hour),
 			)
 			assert.Nil(t, err)
 
@@ -203,3 +209,247 @@ func TestClient(t *testing.T) {
 		})
 	}
 }
+
+func TestServerRenewal(t *testing.T) {
+	testKey := "testKey"
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		claims      jwtV5.MapClaims
+		opts        []Option
+		wantRenewal bool
+		wantExp     time.Time
+	}{
+		{
+			name:        "within window",
+			claims:      jwtV5.MapClaims{"sub": "fly", "iat": now.Add(-55 * time.Minute).Unix(), "exp": now.Add(5 * time.Minute).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute)},
+			wantRenewal: true,
+			wantExp:     now.Add(time.Hour),
+		},
+		{
+			name:        "outside window",
+			claims:      jwtV5.MapClaims{"sub": "fly", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute)},
+			wantRenewal: false,
+		},
+		{
+			name:        "capped by max session lifetime",
+			claims:      jwtV5.MapClaims{"sub": "fly", "auth_time": now.Add(-90 * time.Minute).Unix(), "iat": now.Add(-55 * time.Minute).Unix(), "exp": now.Add(5 * time.Minute).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute), WithMaxSessionLifetime(2 * time.Hour)},
+			wantRenewal: true,
+			wantExp:     now.Add(30 * time.Minute),
+		},
+		{
+			name:        "session starts at iat without auth_time",
+			claims:      jwtV5.MapClaims{"sub": "fly", "iat": now.Add(-55 * time.Minute).Unix(), "exp": now.Add(5 * time.Minute).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute), WithMaxSessionLifetime(80 * time.Minute)},
+			wantRenewal: true,
+			wantExp:     now.Add(25 * time.Minute),
+		},
+		{
+			name:        "session started at iat has reached max lifetime",
+			claims:      jwtV5.MapClaims{"sub": "fly", "iat": now.Add(-55 * time.Minute).Unix(), "exp": now.Add(5 * time.Minute).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute), WithMaxSessionLifetime(50 * time.Minute)},
+			wantRenewal: false,
+		},
+		{
+			name:        "no iat",
+			claims:      jwtV5.MapClaims{"sub": "fly", "exp": now.Add(5 * time.Minute).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute)},
+			wantRenewal: false,
+		},
+		{
+			name:        "max session lifetime reached",
+			claims:      jwtV5.MapClaims{"sub": "fly", "auth_time": now.Add(-3 * time.Hour).Unix(), "iat": now.Add(-55 * time.Minute).Unix(), "exp": now.Add(5 * time.Minute).Unix()},
+			opts:        []Option{WithRenewal(10 * time.Minute), WithMaxSessionLifetime(2 * time.Hour)},
+			wantRenewal: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator, err := jwt.NewAuthenticator(jwt.WithKey([]byte(testKey)))
+			assert.Nil(t, err)
+
+			token, err := jwtV5.NewWithClaims(jwtV5.SigningMethodHS256, test.claims).SignedString([]byte(testKey))
+			assert.Nil(t, err)
+
+			reply := headerCarrier{}
+			ctx := transport.NewServerContext(context.Background(), &Transport{
+				reqHeader:   newTokenHeader(engine.HeaderAuthorize, token),
+				replyHeader: reply,
+			})
+
+			next := func(ctx context.Context, req interface{}) (interface{}, error) { return "reply", nil }
+			_, err = Server(authenticator, test.opts...)(next)(ctx, test.name)
+			assert.Nil(t, err)
+
+			renewed := reply.Get(DefaultRenewalHeader)
+			if !test.wantRenewal {
+				assert.Empty(t, renewed)
+				return
+			}
+			assert.NotEmpty(t, renewed)
+
+			claims, err := authenticator.AuthenticateToken(renewed)
+			assert.Nil(t, err)
+
+			sub, _ := claims.GetSubject()
+			assert.Equal(t, "fly", sub)
+
+			exp, err := claims.GetExpirationTime()
+			assert.Nil(t, err)
+			assert.WithinDuration(t, test.wantExp, exp.Time, 2*time.Second)
+		})
+	}
+}
+
+func TestServerRenewalRepeated(t *testing.T) {
+	testKey := "testKey"
+	now := time.Now()
+
+	// A plain authenticator does not set "iat" itself.
+	authenticator, err := jwt.NewAuthenticator(jwt.WithKey([]byte(testKey)))
+	assert.Nil(t, err)
+
+	token, err := jwtV5.NewWithClaims(jwtV5.SigningMethodHS256, jwtV5.MapClaims{
+		"sub": "fly",
+		"iat": now.Add(-55 * time.Minute).Unix(),
+		"nbf": now.Add(-56 * time.Minute).Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}).SignedString([]byte(testKey))
+	assert.Nil(t, err)
+
+	// A revocation of the subject before the session does not affect it.
+	assert.Nil(t, authenticator.(*jwt.Authenticator).RevokeSubject("fly", now.Add(-2*time.Hour)))
+
+	// The window covers the whole token lifetime, so that every renewed
+	// token is renewed again.
+	server := Server(authenticator, WithRenewal(2*time.Hour))
+	next := func(ctx context.Context, req interface{}) (interface{}, error) { return "reply", nil }
+
+	for i := 0; i < 3; i++ {
+		reply := headerCarrier{}
+		ctx := transport.NewServerContext(context.Background(), &Transport{
+			reqHeader:   newTokenHeader(engine.HeaderAuthorize, token),
+			replyHeader: reply,
+		})
+		_, err = server(next)(ctx, "ok")
+		assert.Nil(t, err)
+
+		renewed := reply.Get(DefaultRenewalHeader)
+		if !assert.NotEmpty(t, renewed, "round %d", i) {
+			return
+		}
+
+		claims, err := authenticator.AuthenticateToken(renewed)
+		if !assert.Nil(t, err, "round %d", i) {
+			return
+		}
+
+		iat, err := claims.GetIssuedAt()
+		assert.Nil(t, err)
+		if assert.NotNil(t, iat) {
+			assert.WithinDuration(t, now, iat.Time, 2*time.Second)
+		}
+		nbf, err := claims.GetNotBefore()
+		assert.Nil(t, err)
+		if assert.NotNil(t, nbf) {
+			assert.WithinDuration(t, now.Add(-time.Minute), nbf.Time, 2*time.Second)
+		}
+		exp, err := claims.GetExpirationTime()
+		assert.Nil(t, err)
+		assert.WithinDuration(t, now.Add(time.Hour), exp.Time, 2*time.Second)
+
+		token = renewed
+	}
+}
+
+func TestServerRenewalCookie(t *testing.T) {
+	testKey := "testKey"
+	now := time.Now()
+
+	authenticator, err := jwt.NewAuthenticator(jwt.WithKey([]byte(testKey)))
+	assert.Nil(t, err)
+
+	token, err := jwtV5.NewWithClaims(jwtV5.SigningMethodHS256, jwtV5.MapClaims{
+		"sub": "fly",
+		"iat": now.Add(-55 * time.Minute).Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}).SignedString([]byte(testKey))
+	assert.Nil(t, err)
+
+	reply := headerCarrier{}
+	ctx := transport.NewServerContext(context.Background(), &Transport{
+		reqHeader:   newTokenHeader(engine.HeaderAuthorize, token),
+		replyHeader: reply,
+	})
+
+	server := Server(authenticator,
+		WithRenewal(10*time.Minute),
+		WithRenewalHeader(""),
+		WithRenewalCookie(http.Cookie{Name: "session", Path: "/", HttpOnly: true, Secure: true}),
+	)
+	next := func(ctx context.Context, req interface{}) (interface{}, error) { return "reply", nil }
+	_, err = server(next)(ctx, "ok")
+	assert.Nil(t, err)
+
+	assert.Empty(t, reply.Get(DefaultRenewalHeader))
+
+	cookie := reply.Get("Set-Cookie")
+	assert.True(t, strings.HasPrefix(cookie, "session="))
+	assert.Contains(t, cookie, "HttpOnly")
+
+	cookies, err := http.ParseSetCookie(cookie)
+	assert.Nil(t, err)
+	_, err = authenticator.AuthenticateToken(cookies.Value)
+	assert.Nil(t, err)
+}
+
+func TestServerRenewalOIDC(t *testing.T) {
+	const issuerURL = "http://localhost:8086"
+	const audience = "kratos.dev"
+	now := time.Now()
+
+	server, err := oidc.NewMockOidcServer(issuerURL)
+	assert.Nil(t, err)
+	defer server.Close()
+
+	// The service has client credentials, but its token must not be handed
+	// out to users as a renewed token.
+	authenticator, err := oidc.NewAuthenticator(
+		oidc.WithIssuerURL(issuerURL),
+		oidc.WithAudience(audience),
+		oidc.WithClientCredentials("service", "secret"),
+	)
+	assert.Nil(t, err)
+	defer authenticator.Close()
+
+	token, err := server.SignClaims(jwtV5.MapClaims{
+		"iss": issuerURL,
+		"aud": audience,
+		"sub": "fly",
+		"iat": now.Add(-55 * time.Minute).Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	})
+	assert.Nil(t, err)
+
+	reply := headerCarrier{}
+	ctx := transport.NewServerContext(context.Background(), &Transport{
+		reqHeader:   newTokenHeader(engine.HeaderAuthorize, token),
+		replyHeader: reply,
+	})
+
+	middleware := Server(authenticator,
+		WithRenewal(10*time.Minute),
+		WithRenewalCookie(http.Cookie{Name: "session", Path: "/"}),
+	)
+	next := func(ctx context.Context, req interface{}) (interface{}, error) { return "reply", nil }
+	_, err = middleware(next)(ctx, "ok")
+	assert.Nil(t, err)
+
+	assert.Empty(t, reply.Values(DefaultRenewalHeader))
+	assert.Empty(t, reply.Values("Set-Cookie"))
+}
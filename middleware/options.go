@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/tx7do/kratos-authn/engine"
 )
@@ -8,8 +11,9 @@ import (
 type Option func(*options)
 
 type options struct {
-	claims engine.AuthClaims
-	log    *log.Helper
+	claims  engine.AuthClaims
+	log     *log.Helper
+	renewal *renewal
 }
 
 func WithAuthClaims(claims engine.AuthClaims) Option {
@@ -23,3 +27,45 @@ func WithLogger(logger log.Logger) Option {
 		o.log = log.NewHelper(log.With(logger, "module", "authn.middleware"))
 	}
 }
+
+// WithRenewal makes Server renew tokens that expire within window: the
+// claims are re-minted through the authenticator's CreateIdentity and the new
+// token is returned in the DefaultRenewalHeader reply header.
+func WithRenewal(window time.Duration) Option {
+	return func(o *options) {
+		o.getRenewal().window = window
+	}
+}
+
+// WithRenewalHeader sets the reply header carrying a renewed token.
+// An empty name disables the header, e.g. when only a cookie is wanted.
+func WithRenewalHeader(name string) Option {
+	return func(o *options) {
+		o.getRenewal().header = name
+	}
+}
+
+// WithRenewalCookie also returns a renewed token as a cookie built from the
+// template (name, path, domain, Secure, HttpOnly, SameSite). Its value and
+// expiry are set from the renewed token.
+func WithRenewalCookie(template http.Cookie) Option {
+	return func(o *options) {
+		o.getRenewal().cookie = &template
+	}
+}
+
+// WithMaxSessionLifetime stops renewal once lifetime has passed since the
+// session started, and never extends a renewed token past that point. The
+// session start is the "auth_time" claim, or the first token's "iat".
+func WithMaxSessionLifetime(lifetime time.Duration) Option {
+	return func(o *options) {
+		o.getRenewal().maxLifetime = lifetime
+	}
+}
+
+func (o *options) getRenewal() *renewal {
+	if o.renewal == nil {
+		o.renewal = &renewal{header: DefaultRenewalHeader, now: time.Now}
+	}
+	return o.renewal
+}
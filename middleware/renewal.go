@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/transport"
+
+	"github.com/tx7do/kratos-authn/engine"
+)
+
+// DefaultRenewalHeader is the reply header carrying a renewed token.
+const DefaultRenewalHeader = "X-Renewed-Token"
+
+// renewal configures sliding-session token renewal.
+type renewal struct {
+	// window renews tokens that expire within it.
+	window time.Duration
+	// header, when not empty, carries the renewed token in the reply.
+	header string
+	// cookie, when set, is the template of a cookie carrying the renewed token.
+	cookie *http.Cookie
+	// maxLifetime caps the session, counted from "auth_time".
+	maxLifetime time.Duration
+
+	now func() time.Time
+}
+
+// renewIdentity re-mints the token of claims through the authenticator when
+// it is close to expiry, and sets it in the reply header and/or cookie.
+// Failures are logged; the request itself was already authenticated.
+func (o *options) renewIdentity(ctx context.Context, authenticator engine.Authenticator, claims *engine.AuthClaims) {
+	r := o.renewal
+	if r == nil || claims == nil {
+		return
+	}
+
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok || tr.ReplyHeader() == nil {
+		return
+	}
+
+	renewed, expiresAt, ok := r.renewedClaims(*claims)
+	if !ok {
+		return
+	}
+
+	token, err := authenticator.CreateIdentity(renewed)
+	if err != nil {
+		o.log.Errorf("authenticator middleware renew token failed: %s", err.Error())
+		return
+	}
+	// Authenticators of tokens issued elsewhere, e.g. oidc, cannot renew.
+	if token == "" {
+		return
+	}
+
+	if r.header != "" {
+		tr.ReplyHeader().Set(r.header, token)
+	}
+	if r.cookie != nil {
+		cookie := *r.cookie
+		cookie.Value = token
+		if !expiresAt.IsZero() {
+			cookie.Expires = expiresAt
+		}
+		tr.ReplyHeader().Add("Set-Cookie", cookie.String())
+	}
+}
+
+// renewedClaims returns the claims for the renewed token and its expiration
+// time, or false when the token does not need renewal, cannot be renewed or
+// the session has reached its maximum lifetime.
+//
+// The renewed token keeps the lifetime of the current one ("exp" - "iat"),
+// so a token without "iat" is not renewed. It is issued now: "iat" is set,
+// and so is "nbf" if present, keeping its offset to "iat", so that the
+// renewed token can be renewed in turn and is not caught by revocations of
+// the subject that predate it. "jti" is left to the authenticator.
+func (r *renewal) renewedClaims(claims engine.AuthClaims) (engine.AuthClaims, time.Time, bool) {
+	now := r.now()
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil || exp.Time.Sub(now) > r.window {
+		return nil, time.Time{}, false
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil || !iat.Time.Before(exp.Time) {
+		return nil, time.Time{}, false
+	}
+
+	renewed := make(engine.AuthClaims, len(claims))
+	for k, v := range claims {
+		renewed[k] = v
+	}
+	delete(renewed, engine.ClaimFieldJwtID)
+
+	renewed[engine.ClaimFieldIssuedAt] = now.Unix()
+	if nbf, err := claims.GetNotBefore(); err != nil {
+		return nil, time.Time{}, false
+	} else if nbf != nil {
+		renewed[engine.ClaimFieldNotBefore] = now.Add(nbf.Time.Sub(iat.Time)).Unix()
+	}
+
+	newExp := now.Add(exp.Time.Sub(iat.Time))
+
+	if r.maxLifetime > 0 {
+		// The session starts at "auth_time", which is carried over to every
+		// renewed token. A token without it starts the session at "iat".
+		authTime, err := claims.GetInt64(engine.ClaimFieldAuthTime)
+		if err != nil {
+			return nil, time.Time{}, false
+		}
+		if _, ok := claims[engine.ClaimFieldAuthTime]; !ok {
+			authTime = iat.Unix()
+			renewed[engine.ClaimFieldAuthTime] = authTime
+		}
+
+		deadline := time.Unix(authTime, 0).Add(r.maxLifetime)
+		if !now.Before(deadline) {
+			return nil, time.Time{}, false
+		}
+		if newExp.After(deadline) {
+			newExp = deadline
+		}
+	}
+
+	renewed[engine.ClaimFieldExpirationTime] = newExp.Unix()
+
+	return renewed, newExp, true
+}